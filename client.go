@@ -0,0 +1,309 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/rancher/norman/httperror"
+	"github.com/rancher/norman/parse/builder"
+	"github.com/rancher/norman/store/proxy"
+	"github.com/rancher/norman/types"
+	"github.com/rancher/norman/types/convert"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	apiextensionsv1beta1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1beta1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/rest"
+)
+
+// same headers used by proxy store to act on behalf of current user
+var impersonateHeaders = []string{
+	"Impersonate-User",
+	"Impersonate-Group",
+}
+
+// resourceClient reads and writes k8s objects in their native form,
+// used when mappers of a schema get in the way, e.g. to update status
+type resourceClient struct {
+	clientGetter proxy.ClientGetter
+	prefix       []string
+	resource     string
+}
+
+func newCRDClient(clientGetter proxy.ClientGetter, crd *apiextensionsv1beta1.CustomResourceDefinition) *resourceClient {
+	return &resourceClient{
+		clientGetter: clientGetter,
+		prefix:       []string{"apis", crd.Spec.Group, crd.Spec.Version},
+		resource:     crd.Spec.Names.Plural,
+	}
+}
+
+func newCoreClient(clientGetter proxy.ClientGetter, resource string) *resourceClient {
+	return &resourceClient{
+		clientGetter: clientGetter,
+		prefix:       []string{"api", "v1"},
+		resource:     resource,
+	}
+}
+
+func newGroupClient(clientGetter proxy.ClientGetter, group, version, resource string) *resourceClient {
+	return &resourceClient{
+		clientGetter: clientGetter,
+		prefix:       []string{"apis", group, version},
+		resource:     resource,
+	}
+}
+
+func (c *resourceClient) Get(apiContext *types.APIContext, namespace, name string, into interface{}) error {
+	return c.do(apiContext, func(client rest.Interface) *rest.Request {
+		return c.common(namespace, client.Get()).Name(name)
+	}, into)
+}
+
+func (c *resourceClient) List(apiContext *types.APIContext, namespace, labelSelector string, into interface{}) error {
+	return c.do(apiContext, func(client rest.Interface) *rest.Request {
+		req := c.common(namespace, client.Get())
+		if labelSelector != "" {
+			req.Param("labelSelector", labelSelector)
+		}
+		return req
+	}, into)
+}
+
+func (c *resourceClient) Create(apiContext *types.APIContext, namespace string, obj interface{}) error {
+	body, err := json.Marshal(obj)
+	if err != nil {
+		return err
+	}
+	return c.do(apiContext, func(client rest.Interface) *rest.Request {
+		return c.common(namespace, client.Post()).Body(body)
+	}, obj)
+}
+
+func (c *resourceClient) Update(apiContext *types.APIContext, namespace, name string, obj interface{}) error {
+	body, err := json.Marshal(obj)
+	if err != nil {
+		return err
+	}
+	return c.do(apiContext, func(client rest.Interface) *rest.Request {
+		return c.common(namespace, client.Put()).Name(name).Body(body)
+	}, obj)
+}
+
+func (c *resourceClient) Delete(apiContext *types.APIContext, namespace, name string) error {
+	return c.do(apiContext, func(client rest.Interface) *rest.Request {
+		return c.common(namespace, client.Delete()).Name(name)
+	}, nil)
+}
+
+func (c *resourceClient) common(namespace string, req *rest.Request) *rest.Request {
+	req.Prefix(c.prefix...).Resource(c.resource)
+	if namespace != "" {
+		req.Namespace(namespace)
+	}
+	return req
+}
+
+// Stream opens a stream of given subresource of an object, e.g. logs of a pod
+func (c *resourceClient) Stream(apiContext *types.APIContext, namespace, name, subresource string, params map[string]string) (io.ReadCloser, error) {
+	req, err := c.request(apiContext, func(client rest.Interface) *rest.Request {
+		req := c.common(namespace, client.Get()).Name(name).SubResource(subresource)
+		for k, v := range params {
+			req.Param(k, v)
+		}
+		return req
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	stream, err := req.Stream()
+	if err != nil {
+		return nil, translateError(err)
+	}
+	return stream, nil
+}
+
+// Watch opens a stream of watch events of objects changed since resourceVersion
+func (c *resourceClient) Watch(apiContext *types.APIContext, namespace, resourceVersion string) (io.ReadCloser, error) {
+	req, err := c.request(apiContext, func(client rest.Interface) *rest.Request {
+		return c.common(namespace, client.Get()).
+			Param("watch", "true").
+			Param("resourceVersion", resourceVersion)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	stream, err := req.Stream()
+	if err != nil {
+		return nil, translateError(err)
+	}
+	return stream, nil
+}
+
+func (c *resourceClient) request(apiContext *types.APIContext, build func(rest.Interface) *rest.Request) (*rest.Request, error) {
+	client, err := c.clientGetter.UnversionedClient(apiContext, types.DefaultStorageContext)
+	if err != nil {
+		return nil, err
+	}
+
+	req := build(client)
+	if apiContext != nil {
+		for _, header := range impersonateHeaders {
+			req.SetHeader(header, apiContext.Request.Header[http.CanonicalHeaderKey(header)]...)
+		}
+	}
+	return req, nil
+}
+
+func (c *resourceClient) do(apiContext *types.APIContext, build func(rest.Interface) *rest.Request, into interface{}) error {
+	req, err := c.request(apiContext, build)
+	if err != nil {
+		return err
+	}
+
+	raw, err := req.Do().Raw()
+	if err != nil {
+		return translateError(err)
+	}
+	if into == nil {
+		return nil
+	}
+	return json.Unmarshal(raw, into)
+}
+
+// accessReviewer checks permissions of current user against k8s RBAC
+type accessReviewer struct {
+	reviews *resourceClient
+}
+
+func newAccessReviewer(clientGetter proxy.ClientGetter) *accessReviewer {
+	return &accessReviewer{
+		reviews: newGroupClient(clientGetter, authorizationv1.GroupName, "v1", "selfsubjectaccessreviews"),
+	}
+}
+
+// Check returns PermissionDenied if current user is not allowed to perform the given request
+func (r *accessReviewer) Check(apiContext *types.APIContext, attrs authorizationv1.ResourceAttributes) error {
+	review := &authorizationv1.SelfSubjectAccessReview{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: authorizationv1.SchemeGroupVersion.String(),
+			Kind:       "SelfSubjectAccessReview",
+		},
+		Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+			ResourceAttributes: &attrs,
+		},
+	}
+	if err := r.reviews.Create(apiContext, "", review); err != nil {
+		return err
+	}
+	if !review.Status.Allowed {
+		resource := attrs.Resource
+		if attrs.Subresource != "" {
+			resource += "/" + attrs.Subresource
+		}
+		return httperror.NewAPIError(httperror.PermissionDenied,
+			strings.TrimSpace(fmt.Sprintf("can not %s %s %s", attrs.Verb, resource, attrs.Name)))
+	}
+	return nil
+}
+
+// translateError converts k8s status to api error, copied from norman's proxy store
+func translateError(err error) error {
+	if apiError, ok := err.(errors.APIStatus); ok {
+		status := apiError.Status()
+		return httperror.NewAPIErrorLong(int(status.Code), string(status.Reason), status.Message)
+	}
+	return err
+}
+
+// aggregateFieldErrors returns errors of fields found by a validator as one, in code of the first,
+// errors other than api errors, e.g. failures to reach k8s, are returned as they are
+func aggregateFieldErrors(errs []error) error {
+	if len(errs) == 0 {
+		return nil
+	}
+	if len(errs) == 1 {
+		return errs[0]
+	}
+
+	messages := []string{}
+	for _, err := range errs {
+		apiError, ok := err.(*httperror.APIError)
+		if !ok {
+			return err
+		}
+		message := apiError.Message
+		if message == "" {
+			message = apiError.Code.Code
+		}
+		if apiError.FieldName != "" {
+			message = apiError.FieldName + ": " + message
+		}
+		messages = append(messages, message)
+	}
+	return httperror.NewAPIError(errs[0].(*httperror.APIError).Code, strings.Join(messages, "; "))
+}
+
+// splitID splits a namespaced id "<ns>:<name>"
+func splitID(id string) (string, string) {
+	namespace := ""
+	parts := strings.SplitN(id, ":", 2)
+	if len(parts) == 2 {
+		namespace = parts[0]
+		id = parts[1]
+	}
+	return namespace, id
+}
+
+// readInput decodes the body of an action into input, an empty body is allowed,
+// the body is checked against the input schema of action as norman does for resources, e.g. for defaults, options and limits
+func readInput(apiContext *types.APIContext, action *types.Action, input interface{}) error {
+	data := map[string]interface{}{}
+	if err := json.NewDecoder(apiContext.Request.Body).Decode(&data); err != nil && err != io.EOF {
+		return httperror.NewAPIError(httperror.InvalidBodyContent, fmt.Sprintf("failed to parse body: %v", err))
+	}
+
+	if schema := apiContext.Schemas.Schema(apiContext.Version, action.Input); schema != nil {
+		var err error
+		if data, err = builder.NewBuilder(apiContext).Construct(schema, data, builder.Create); err != nil {
+			return err
+		}
+	}
+	return convert.ToObj(data, input)
+}
+
+// mergeExisting returns data of an update merged onto the object being updated, so validators see the whole object,
+// nested maps are merged key by key like the update itself
+func mergeExisting(apiContext *types.APIContext, schema *types.Schema, data map[string]interface{}) (map[string]interface{}, error) {
+	if apiContext.Method != http.MethodPut {
+		return data, nil
+	}
+	existing, err := schema.Store.ByID(apiContext, schema, apiContext.ID)
+	if err != nil {
+		return nil, err
+	}
+	return mergeMaps(existing, data), nil
+}
+
+// mergeMaps returns a copy of dest with values of src put onto it
+func mergeMaps(dest, src map[string]interface{}) map[string]interface{} {
+	result := map[string]interface{}{}
+	for k, v := range dest {
+		result[k] = v
+	}
+	for k, v := range src {
+		sm, smOk := v.(map[string]interface{})
+		dm, dmOk := result[k].(map[string]interface{})
+		if smOk && dmOk {
+			result[k] = mergeMaps(dm, sm)
+			continue
+		}
+		result[k] = v
+	}
+	return result
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/rancher/norman/httperror"
+	"github.com/rancher/norman/store/proxy"
+	"github.com/rancher/norman/types"
+	"k8s.io/client-go/rest"
+)
+
+// fakeAPIServer serves objects by path like k8s, collections are listed by path prefix
+type fakeAPIServer struct {
+	sync.Mutex
+	*httptest.Server
+
+	objects map[string]map[string]interface{}
+	// user impersonated by the last request of each path
+	users map[string]string
+	// whether access reviews are denied
+	denied bool
+}
+
+func newFakeAPIServer(t *testing.T, objects map[string]map[string]interface{}) (*fakeAPIServer, proxy.ClientGetter) {
+	s := &fakeAPIServer{objects: objects, users: map[string]string{}}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	clientGetter, err := proxy.NewClientGetterFromConfig(rest.Config{Host: s.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, clientGetter
+}
+
+func (s *fakeAPIServer) serve(w http.ResponseWriter, r *http.Request) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.users[r.URL.Path] = r.Header.Get("Impersonate-User")
+	w.Header().Set("Content-Type", "application/json")
+	obj, ok := s.objects[r.URL.Path]
+	switch {
+	case r.Method == http.MethodGet && ok:
+		json.NewEncoder(w).Encode(obj)
+	case r.Method == http.MethodGet && isCollection(r.URL.Path):
+		items := []interface{}{}
+		for path, obj := range s.objects {
+			if strings.HasPrefix(path, r.URL.Path+"/") {
+				items = append(items, obj)
+			}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"kind": "List", "apiVersion": "v1", "items": items})
+	case r.Method == http.MethodPost && strings.HasSuffix(r.URL.Path, "/selfsubjectaccessreviews"):
+		review := map[string]interface{}{}
+		if err := json.NewDecoder(r.Body).Decode(&review); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		review["status"] = map[string]interface{}{"allowed": !s.denied}
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(review)
+	case r.Method == http.MethodPut && ok:
+		obj = map[string]interface{}{}
+		if err := json.NewDecoder(r.Body).Decode(&obj); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		s.objects[r.URL.Path] = obj
+		json.NewEncoder(w).Encode(obj)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"kind": "Status", "apiVersion": "v1", "status": "Failure", "reason": "NotFound", "code": http.StatusNotFound,
+		})
+	}
+}
+
+// isCollection returns whether path is of a collection, e.g. "/api/v1/namespaces/ns1/pods", rather than an object
+func isCollection(path string) bool {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	if parts[0] == "api" {
+		parts = parts[2:]
+	} else {
+		parts = parts[3:]
+	}
+	return len(parts)%2 == 1
+}
+
+func (s *fakeAPIServer) object(path string) map[string]interface{} {
+	s.Lock()
+	defer s.Unlock()
+	return s.objects[path]
+}
+
+func (s *fakeAPIServer) user(path string) (string, bool) {
+	s.Lock()
+	defer s.Unlock()
+	user, ok := s.users[path]
+	return user, ok
+}
+
+func TestMergeMaps(t *testing.T) {
+	dest := map[string]interface{}{
+		"name":      "python3",
+		"container": map[string]interface{}{"image": "python:3", "env": []interface{}{"A=1"}},
+		"poolSize":  1,
+	}
+	src := map[string]interface{}{
+		"container": map[string]interface{}{"image": "python:3.7"},
+		"poolSize":  3,
+	}
+	want := map[string]interface{}{
+		"name":      "python3",
+		"container": map[string]interface{}{"image": "python:3.7", "env": []interface{}{"A=1"}},
+		"poolSize":  3,
+	}
+	if got := mergeMaps(dest, src); !reflect.DeepEqual(got, want) {
+		t.Errorf("mergeMaps() = %v, want %v", got, want)
+	}
+	if image := dest["container"].(map[string]interface{})["image"]; image != "python:3" {
+		t.Errorf("dest is modified, image = %v", image)
+	}
+}
+
+func TestReadInput(t *testing.T) {
+	version := types.APIVersion{Version: "v1", Group: "k8s.refunc.io", Path: "/refunc/v1"}
+	schemas := types.NewSchemas().MustImport(&version, TriggerTestInput{}).MustImport(&version, TriggerRunNowInput{})
+
+	cases := []struct {
+		name  string
+		input string
+		body  string
+		into  interface{}
+		want  interface{}
+		code  httperror.ErrorCode
+	}{
+		{name: "empty body gets defaults", input: "triggerTestInput", into: &TriggerTestInput{}, want: &TriggerTestInput{Method: "POST"}},
+		{name: "option", input: "triggerTestInput", body: `{"method":"PUT","body":"hi"}`, into: &TriggerTestInput{}, want: &TriggerTestInput{Method: "PUT", Body: "hi"}},
+		{name: "not an option", input: "triggerTestInput", body: `{"method":"DELETE"}`, into: &TriggerTestInput{}, code: httperror.InvalidOption},
+		{name: "max", input: "triggerRunNowInput", body: `{"timeout":300}`, into: &TriggerRunNowInput{}, want: &TriggerRunNowInput{Timeout: 300}},
+		{name: "over max", input: "triggerRunNowInput", body: `{"timeout":301}`, into: &TriggerRunNowInput{}, code: httperror.MaxLimitExceeded},
+		{name: "under min", input: "triggerRunNowInput", body: `{"timeout":0}`, into: &TriggerRunNowInput{}, code: httperror.MinLimitExceeded},
+		{name: "malformed", input: "triggerRunNowInput", body: `{`, into: &TriggerRunNowInput{}, code: httperror.InvalidBodyContent},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			apiContext := &types.APIContext{
+				Version: &version,
+				Schemas: schemas,
+				Request: httptest.NewRequest(http.MethodPost, "/refunc/v1/triggers/ns1:tr1?action=test", strings.NewReader(c.body)),
+			}
+			err := readInput(apiContext, &types.Action{Input: c.input}, c.into)
+			if c.code.Code != "" {
+				if apiError, ok := err.(*httperror.APIError); !ok || apiError.Code != c.code {
+					t.Fatalf("readInput() = %v, want %s", err, c.code.Code)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(c.into, c.want) {
+				t.Errorf("readInput() = %+v, want %+v", c.into, c.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	rfv1 "git.v87.us/formicary/refunc/pkg/apis/refunc/v1"
+	"github.com/rancher/norman/httperror"
+	"github.com/rancher/norman/types"
+	"github.com/rancher/norman/types/convert"
+	"github.com/rancher/norman/types/values"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+)
+
+// annotations record the time a function was suspended and the message given
+const (
+	annotationSuspended        = "refunc.io/suspended"
+	annotationSuspendedMessage = "refunc.io/suspended-message"
+)
+
+// reason of inactive condition set to funcinsts of a suspended function
+const reasonSuspended = "FuncdefSuspended"
+
+// FuncdefDeleteReport lists objects removed along with a funcdef
+type FuncdefDeleteReport struct {
+	FuncdefID string   `json:"funcdefId"`
+	Triggers  []string `json:"triggers"`
+	Funcinsts []string `json:"funcinsts"`
+}
+
+// FuncdefSuspendInput is input for suspend action
+type FuncdefSuspendInput struct {
+	Message string `json:"message"`
+}
+
+type funcdefHandler struct {
+	funcdeves *resourceClient
+	funcinsts *resourceClient
+	triggers  *resourceClient
+	xenvs     *xenvHandler
+}
+
+// validator checks the runtime of a funcdef refers to a xenv in its namespace or the shared one,
+// nothing is checked unless the shared namespace is set
+func (h *funcdefHandler) validator(apiContext *types.APIContext, schema *types.Schema, data map[string]interface{}) error {
+	if h.xenvs.sharedNamespace == "" {
+		return nil
+	}
+	fnd, err := mergeExisting(apiContext, schema, data)
+	if err != nil {
+		return err
+	}
+	namespace := convert.ToString(fnd["namespaceId"])
+	runtime, _ := values.GetValue(fnd, "runtime", "name")
+	name := convert.ToString(runtime)
+	if namespace == "" || name == "" {
+		// default builder is used if runtime is not set
+		return nil
+	}
+
+	_, err = h.xenvs.resolve(apiContext, namespace, name)
+	return err
+}
+
+func (h *funcdefHandler) formatter(apiContext *types.APIContext, resource *types.RawResource) {
+	if _, suspended := values.GetValue(resource.Values, "annotations", annotationSuspended); suspended {
+		resource.AddAction(apiContext, "resume")
+	} else {
+		resource.AddAction(apiContext, "suspend")
+	}
+}
+
+func (h *funcdefHandler) actionHandler(actionName string, action *types.Action, apiContext *types.APIContext) error {
+	switch actionName {
+	case "suspend":
+		input := FuncdefSuspendInput{}
+		if err := readInput(apiContext, action, &input); err != nil {
+			return err
+		}
+		if input.Message == "" {
+			input.Message = "Function was suspended"
+		}
+		return h.setSuspended(apiContext, true, input.Message)
+	case "resume":
+		return h.setSuspended(apiContext, false, "")
+	}
+	return httperror.NewAPIError(httperror.InvalidAction, "invalid action: "+actionName)
+}
+
+// setSuspended (de)activates all funcinsts of a function and marks the function accordingly
+func (h *funcdefHandler) setSuspended(apiContext *types.APIContext, suspend bool, message string) error {
+	namespace, name := splitID(apiContext.ID)
+
+	fnd := &rfv1.Funcdef{}
+	if err := h.funcdeves.Get(apiContext, namespace, name, fnd); err != nil {
+		return err
+	}
+
+	// marked first, so that funcinsts created from now on are deactivated by suspender
+	if suspend {
+		if fnd.Annotations == nil {
+			fnd.Annotations = map[string]string{}
+		}
+		fnd.Annotations[annotationSuspended] = time.Now().Format(time.RFC3339)
+		fnd.Annotations[annotationSuspendedMessage] = message
+	} else {
+		delete(fnd.Annotations, annotationSuspended)
+		delete(fnd.Annotations, annotationSuspendedMessage)
+	}
+	if err := h.funcdeves.Update(apiContext, namespace, name, fnd); err != nil {
+		return err
+	}
+
+	fnis := &rfv1.FuncinstList{}
+	if err := h.funcinsts.List(apiContext, namespace, rfv1.LabelName+"="+name, fnis); err != nil {
+		return err
+	}
+	for i := range fnis.Items {
+		fni := &fnis.Items[i]
+		if suspend {
+			fni.Status.SetInactiveCondition(reasonSuspended, message)
+		} else if _, inactive := findFuncinstCondition(&fni.Status, rfv1.FuncinstInactive); inactive != nil && inactive.Reason == reasonSuspended {
+			fni.Status.ClearCondition(rfv1.FuncinstInactive)
+		} else {
+			continue
+		}
+		if err := h.funcinsts.Update(apiContext, namespace, fni.Name, fni); err != nil {
+			return err
+		}
+	}
+
+	data, err := apiContext.Schema.Store.ByID(apiContext, apiContext.Schema, apiContext.ID)
+	if err != nil {
+		return err
+	}
+	apiContext.WriteResponse(http.StatusOK, data)
+	return nil
+}
+
+// suspender deactivates funcinsts created for a suspended function, since setSuspended only marks existing ones,
+// it acts as the service account as funcinsts are created on demand rather than by users
+type suspender struct {
+	funcdeves *resourceClient
+	funcinsts *resourceClient
+}
+
+func (s *suspender) FuncinstAdded(fni *rfv1.Funcinst) {
+	if fni.Spec.FuncdefRef == nil {
+		return
+	}
+	if _, inactive := findFuncinstCondition(&fni.Status, rfv1.FuncinstInactive); inactive != nil && inactive.Status == corev1.ConditionTrue {
+		return
+	}
+
+	fnd := &rfv1.Funcdef{}
+	if err := s.funcdeves.Get(nil, fni.Spec.FuncdefRef.Namespace, fni.Spec.FuncdefRef.Name, fnd); err != nil {
+		logrus.Debugf("failed to get funcdef of %s/%s, %v", fni.Namespace, fni.Name, err)
+		return
+	}
+	if _, suspended := fnd.Annotations[annotationSuspended]; !suspended {
+		return
+	}
+
+	fni.Status.SetInactiveCondition(reasonSuspended, fnd.Annotations[annotationSuspendedMessage])
+	if err := s.funcinsts.Update(nil, fni.Namespace, fni.Name, fni); err != nil {
+		logrus.Warnf("failed to deactivate %s/%s of suspended function, %v", fni.Namespace, fni.Name, err)
+	}
+}
+
+// deleteHandler removes triggers and funcinsts of a funcdef when "cascade=true" is given,
+// the funcdef is deleted only after all of them are gone
+func (h *funcdefHandler) deleteHandler(apiContext *types.APIContext, next types.RequestHandler) error {
+	if apiContext.Query.Get("cascade") != "true" {
+		return next(apiContext, nil)
+	}
+
+	namespace, name := splitID(apiContext.ID)
+	if err := h.funcdeves.Get(apiContext, namespace, name, &rfv1.Funcdef{}); err != nil {
+		return err
+	}
+
+	report := FuncdefDeleteReport{
+		FuncdefID: apiContext.ID,
+		Triggers:  []string{},
+		Funcinsts: []string{},
+	}
+	errs := []error{}
+
+	trs := &rfv1.TriggerList{}
+	if err := h.triggers.List(apiContext, namespace, "", trs); err != nil {
+		return err
+	}
+	for _, tr := range trs.Items {
+		if tr.Spec.FuncName != name {
+			continue
+		}
+		if err := h.triggers.Delete(apiContext, namespace, tr.Name); err != nil {
+			errs = append(errs, fmt.Errorf("trigger %s: %v", tr.Name, err))
+			continue
+		}
+		report.Triggers = append(report.Triggers, namespace+":"+tr.Name)
+	}
+
+	fnis := &rfv1.FuncinstList{}
+	if err := h.funcinsts.List(apiContext, namespace, rfv1.LabelName+"="+name, fnis); err != nil {
+		return err
+	}
+	for _, fni := range fnis.Items {
+		if err := h.funcinsts.Delete(apiContext, namespace, fni.Name); err != nil {
+			errs = append(errs, fmt.Errorf("funcinst %s: %v", fni.Name, err))
+			continue
+		}
+		report.Funcinsts = append(report.Funcinsts, namespace+":"+fni.Name)
+	}
+
+	if len(errs) > 0 {
+		return httperror.NewAPIError(httperror.ServerError, fmt.Sprintf(
+			"funcdef %s is kept, failed to delete %v, deleted triggers %v and funcinsts %v",
+			apiContext.ID, utilerrors.NewAggregate(errs), report.Triggers, report.Funcinsts))
+	}
+
+	if err := h.funcdeves.Delete(apiContext, namespace, name); err != nil {
+		return err
+	}
+
+	data, err := convert.EncodeToMap(report)
+	if err != nil {
+		return err
+	}
+	data["type"] = "funcdefDeleteReport"
+	apiContext.WriteResponse(http.StatusOK, data)
+	return nil
+}
+
+// findFuncinstCondition is a copy of unexported getFuncinstCondition from rfv1
+func findFuncinstCondition(status *rfv1.FuncinstStatus, t rfv1.FuncinstConditionType) (int, *rfv1.FuncinstCondition) {
+	for i := range status.Conditions {
+		if t == status.Conditions[i].Type {
+			return i, &status.Conditions[i]
+		}
+	}
+	return -1, nil
+}
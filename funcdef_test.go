@@ -0,0 +1,61 @@
+package main
+
+import (
+	"testing"
+
+	rfv1 "git.v87.us/formicary/refunc/pkg/apis/refunc/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestSuspenderDeactivatesNewFuncinsts(t *testing.T) {
+	funcdef := func(name string, annotations map[string]interface{}) map[string]interface{} {
+		return map[string]interface{}{
+			"apiVersion": "k8s.refunc.io/v1",
+			"kind":       "Funcdef",
+			"metadata":   map[string]interface{}{"name": name, "namespace": "ns1", "annotations": annotations},
+		}
+	}
+	server, clientGetter := newFakeAPIServer(t, map[string]map[string]interface{}{
+		"/apis/k8s.refunc.io/v1/namespaces/ns1/funcdeves/suspended": funcdef("suspended", map[string]interface{}{
+			annotationSuspended:        "2026-10-19T03:00:00Z",
+			annotationSuspendedMessage: "paused for maintenance",
+		}),
+		"/apis/k8s.refunc.io/v1/namespaces/ns1/funcdeves/running": funcdef("running", nil),
+	})
+	defer server.Close()
+
+	s := &suspender{
+		funcdeves: newCRDClient(clientGetter, rfv1.CRDs[0].CRD),
+		funcinsts: newCRDClient(clientGetter, rfv1.CRDs[3].CRD),
+	}
+	for _, funcName := range []string{"suspended", "running"} {
+		path := "/apis/k8s.refunc.io/v1/namespaces/ns1/funcinsts/" + funcName + "-1"
+		server.Lock()
+		server.objects[path] = map[string]interface{}{
+			"apiVersion": "k8s.refunc.io/v1",
+			"kind":       "Funcinst",
+			"metadata":   map[string]interface{}{"name": funcName + "-1", "namespace": "ns1"},
+		}
+		server.Unlock()
+
+		fni := &rfv1.Funcinst{ObjectMeta: metav1.ObjectMeta{Name: funcName + "-1", Namespace: "ns1"}}
+		fni.Spec.FuncdefRef = &corev1.ObjectReference{Namespace: "ns1", Name: funcName}
+		s.FuncinstAdded(fni)
+
+		_, inactive := findFuncinstCondition(&fni.Status, rfv1.FuncinstInactive)
+		switch funcName {
+		case "suspended":
+			if inactive == nil || inactive.Status != corev1.ConditionTrue || inactive.Reason != reasonSuspended || inactive.Message != "paused for maintenance" {
+				t.Errorf("inactive condition of new funcinst of suspended function = %+v", inactive)
+			}
+			if status, _ := server.object(path)["status"].(map[string]interface{}); status == nil {
+				t.Error("deactivated funcinst is not stored")
+			}
+		case "running":
+			if inactive != nil {
+				t.Errorf("new funcinst of running function is deactivated, %+v", inactive)
+			}
+		}
+	}
+}
@@ -0,0 +1,629 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	rfv1 "git.v87.us/formicary/refunc/pkg/apis/refunc/v1"
+	nats "github.com/nats-io/go-nats"
+	"github.com/rancher/norman/httperror"
+	"github.com/rancher/norman/types"
+	"github.com/rancher/norman/types/convert"
+	"github.com/rancher/norman/types/values"
+	"github.com/tidwall/gjson"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// value shown in place of credentials
+const maskedCredential = "********"
+
+// FuncinstDeactivateInput is input for deactivate action
+type FuncinstDeactivateInput struct {
+	Reason  string `json:"reason" norman:"required"`
+	Message string `json:"message"`
+}
+
+// FuncinstPingInput is input for ping action
+type FuncinstPingInput struct {
+	// seconds to wait for the cry of funcinst
+	Timeout int `json:"timeout" norman:"default=5,min=1,max=60"`
+}
+
+// FuncinstPingOutput is output of ping action
+type FuncinstPingOutput struct {
+	Answered  bool  `json:"answered"`
+	LatencyMS int64 `json:"latencyMs"`
+}
+
+// FuncinstPod is an executor pod of a funcinst
+type FuncinstPod struct {
+	Name      string `json:"name"`
+	NodeName  string `json:"nodeName"`
+	Phase     string `json:"phase"`
+	Ready     bool   `json:"ready"`
+	Restarts  int    `json:"restarts"`
+	Created   string `json:"created"`
+	LogsURL   string `json:"logsUrl"`
+	Container string `json:"container"`
+}
+
+// PermissionsDrift shows differences between permissions of a funcinst and the default ones
+type PermissionsDrift struct {
+	Drifted          bool     `json:"drifted"`
+	Scope            string   `json:"scope,omitempty"`
+	PublishAdded     []string `json:"publishAdded,omitempty"`
+	PublishRemoved   []string `json:"publishRemoved,omitempty"`
+	SubscribeAdded   []string `json:"subscribeAdded,omitempty"`
+	SubscribeRemoved []string `json:"subscribeRemoved,omitempty"`
+}
+
+type funcinstHandler struct {
+	scopeRoot string
+	funcinsts *resourceClient
+	pods      *resourceClient
+	access    *accessReviewer
+	nats      *natsClient
+	logs      *tailHub
+	taps      *tailHub
+	reaper    *reaper
+	timeline  *timeline
+}
+
+func (h *funcinstHandler) formatter(apiContext *types.APIContext, resource *types.RawResource) {
+	resource.Links["logs"] = apiContext.URLBuilder.Link("logs", resource)
+	resource.Links["tap"] = apiContext.URLBuilder.Link("tap", resource)
+	resource.Links["pods"] = apiContext.URLBuilder.Link("pods", resource)
+	resource.Links["timeline"] = apiContext.URLBuilder.Link("timeline", resource)
+	resource.AddAction(apiContext, "revealCredentials")
+	resource.AddAction(apiContext, "ping")
+	if drifted, _ := values.GetValue(resource.Values, "permissionsDrift", "drifted"); convert.ToBool(drifted) {
+		resource.AddAction(apiContext, "resetPermissions")
+	}
+	if resource.Values["state"] == "inactive" {
+		resource.AddAction(apiContext, "reactivate")
+	} else {
+		resource.AddAction(apiContext, "deactivate")
+	}
+}
+
+func (h *funcinstHandler) collectionFormatter(apiContext *types.APIContext, collection *types.GenericCollection) {
+	collection.AddAction(apiContext, "reapReport")
+}
+
+func (h *funcinstHandler) actionHandler(actionName string, action *types.Action, apiContext *types.APIContext) error {
+	switch actionName {
+	case "reapReport":
+		return h.reaper.reportHandler(apiContext)
+	case "revealCredentials":
+		return h.revealCredentials(apiContext)
+	case "deactivate":
+		input := FuncinstDeactivateInput{}
+		if err := readInput(apiContext, action, &input); err != nil {
+			return err
+		}
+		if input.Reason == "" {
+			return httperror.NewFieldAPIError(httperror.MissingRequired, "reason", "")
+		}
+		return h.update(apiContext, func(fni *rfv1.Funcinst) error {
+			fni.Status.SetInactiveCondition(input.Reason, input.Message)
+			return nil
+		})
+	case "ping":
+		input := FuncinstPingInput{}
+		if err := readInput(apiContext, action, &input); err != nil {
+			return err
+		}
+		return h.ping(apiContext, time.Duration(input.Timeout)*time.Second)
+	case "resetPermissions":
+		return h.update(apiContext, func(fni *rfv1.Funcinst) error {
+			if fni.Spec.FuncdefRef == nil {
+				return httperror.NewAPIError(httperror.InvalidState, "funcinst has no funcdef")
+			}
+			fni.Spec.Runtime.Permissions = rfv1.NewDefaultPermissions(fni, scopeRootOf(fni, h.scopeRoot))
+			return nil
+		})
+	case "reactivate":
+		return h.update(apiContext, func(fni *rfv1.Funcinst) error {
+			fni.Status.ClearCondition(rfv1.FuncinstInactive)
+			return nil
+		})
+	}
+	return httperror.NewAPIError(httperror.InvalidAction, "invalid action: "+actionName)
+}
+
+// ping pokes a funcinst to cry and measures how long it takes
+func (h *funcinstHandler) ping(apiContext *types.APIContext, timeout time.Duration) error {
+	fni, err := h.getFuncinst(apiContext)
+	if err != nil {
+		return err
+	}
+
+	conn, err := h.nats.Conn()
+	if err != nil {
+		return err
+	}
+
+	sub, err := conn.SubscribeSync(fni.CryingEndpoint())
+	if err != nil {
+		return err
+	}
+	defer sub.Unsubscribe()
+	if err := conn.Flush(); err != nil {
+		return err
+	}
+
+	start := time.Now()
+	if err := conn.Publish(fni.CryServiceEndpoint(), nil); err != nil {
+		return err
+	}
+
+	output := FuncinstPingOutput{}
+	if _, err := sub.NextMsg(timeout); err == nil {
+		output.Answered = true
+		output.LatencyMS = int64(time.Since(start) / time.Millisecond)
+	} else if err != nats.ErrTimeout {
+		return err
+	}
+
+	data, err := convert.EncodeToMap(output)
+	if err != nil {
+		return err
+	}
+	data["type"] = "funcinstPingOutput"
+	apiContext.WriteResponse(http.StatusOK, data)
+	return nil
+}
+
+// update applies fn to a funcinst, and responses with the updated funcinst, nothing is updated if fn fails
+func (h *funcinstHandler) update(apiContext *types.APIContext, fn func(*rfv1.Funcinst) error) error {
+	namespace, name := splitID(apiContext.ID)
+
+	fni := &rfv1.Funcinst{}
+	if err := h.funcinsts.Get(apiContext, namespace, name, fni); err != nil {
+		return err
+	}
+	if err := fn(fni); err != nil {
+		return err
+	}
+	if err := h.funcinsts.Update(apiContext, namespace, name, fni); err != nil {
+		return err
+	}
+
+	data, err := apiContext.Schema.Store.ByID(apiContext, apiContext.Schema, apiContext.ID)
+	if err != nil {
+		return err
+	}
+	apiContext.WriteResponse(http.StatusOK, data)
+	return nil
+}
+
+// revealCredentials returns unmasked credentials to users who can get "funcinsts/credentials"
+func (h *funcinstHandler) revealCredentials(apiContext *types.APIContext) error {
+	namespace, name := splitID(apiContext.ID)
+	if err := h.access.Check(apiContext, authorizationv1.ResourceAttributes{
+		Namespace:   namespace,
+		Verb:        "get",
+		Group:       rfv1.GroupName,
+		Resource:    rfv1.FuncinstPluralName,
+		Subresource: "credentials",
+		Name:        name,
+	}); err != nil {
+		return err
+	}
+
+	fni := &rfv1.Funcinst{}
+	if err := h.funcinsts.Get(apiContext, namespace, name, fni); err != nil {
+		return err
+	}
+
+	data, err := convert.EncodeToMap(fni.Spec.Runtime.Credentials)
+	if err != nil {
+		return err
+	}
+	data["type"] = "credentials"
+	apiContext.WriteResponse(http.StatusOK, data)
+	return nil
+}
+
+func (h *funcinstHandler) linkHandler(apiContext *types.APIContext, next types.RequestHandler) error {
+	switch apiContext.Link {
+	case "logs":
+		return h.streamLogs(apiContext)
+	case "tap":
+		return h.streamTap(apiContext)
+	case "pods":
+		return h.listPods(apiContext)
+	case "podlogs":
+		return h.streamPodLogs(apiContext)
+	case "timeline":
+		return h.listTimeline(apiContext)
+	}
+	return httperror.NewAPIError(httperror.NotFound, "link not found")
+}
+
+// streamLogs forwards logs of a funcinst to a websocket
+func (h *funcinstHandler) streamLogs(apiContext *types.APIContext) error {
+	fni, err := h.getFuncinst(apiContext)
+	if err != nil {
+		return err
+	}
+
+	backlog, c, cancel, err := h.logs.Watch(fni.LoggingEndpoint())
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	return streamWebsocket(apiContext, backlog, c)
+}
+
+// streamTap forwards request/response frames tapped from a funcinst to a websocket,
+// frames are filtered by "filter=<gjson path>=<value>" and sampled by "sample=<rate>" if given,
+// only users who can get "funcinsts/tap" see the traffic
+func (h *funcinstHandler) streamTap(apiContext *types.APIContext) error {
+	filter, err := newTapFilter(apiContext.Query)
+	if err != nil {
+		return err
+	}
+
+	namespace, name := splitID(apiContext.ID)
+	if err := h.access.Check(apiContext, authorizationv1.ResourceAttributes{
+		Namespace:   namespace,
+		Verb:        "get",
+		Group:       rfv1.GroupName,
+		Resource:    rfv1.FuncinstPluralName,
+		Subresource: "tap",
+		Name:        name,
+	}); err != nil {
+		return err
+	}
+
+	fni, err := h.getFuncinst(apiContext)
+	if err != nil {
+		return err
+	}
+
+	_, c, cancel, err := h.taps.Watch(fni.TappingEndpoint())
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	done := make(chan struct{})
+	defer close(done)
+
+	frames := make(chan []byte)
+	go func() {
+		for {
+			select {
+			case data := <-c:
+				if frame := filter.apply(data); frame != nil {
+					select {
+					case frames <- frame:
+					case <-done:
+						return
+					}
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return streamWebsocket(apiContext, nil, frames)
+}
+
+// listPods lists executor pods of a funcinst to users who can list pods
+func (h *funcinstHandler) listPods(apiContext *types.APIContext) error {
+	fni, err := h.getFuncinst(apiContext)
+	if err != nil {
+		return err
+	}
+
+	pods, err := h.executorPods(apiContext, fni)
+	if err != nil {
+		return err
+	}
+
+	logsURL := apiContext.URLBuilder.ResourceLinkByID(apiContext.Schema, apiContext.ID) + "/podlogs?pod="
+	result := []map[string]interface{}{}
+	for _, pod := range pods {
+		fp := FuncinstPod{
+			Name:     pod.Name,
+			NodeName: pod.Spec.NodeName,
+			Phase:    string(pod.Status.Phase),
+			Ready:    pod.Labels[rfv1.LabelExecutorIsReady] == "true",
+			Created:  pod.CreationTimestamp.Format(time.RFC3339),
+			LogsURL:  logsURL + url.QueryEscape(pod.Name),
+		}
+		if len(pod.Spec.Containers) > 0 {
+			fp.Container = pod.Spec.Containers[0].Name
+		}
+		for _, cs := range pod.Status.ContainerStatuses {
+			fp.Restarts += int(cs.RestartCount)
+		}
+
+		data, err := convert.EncodeToMap(fp)
+		if err != nil {
+			return err
+		}
+		data["type"] = "funcinstPod"
+		result = append(result, data)
+	}
+
+	apiContext.WriteResponse(http.StatusOK, result)
+	return nil
+}
+
+// streamPodLogs forwards logs of an executor pod given by "pod=<name>" to a websocket,
+// "container" and "tailLines" are passed to k8s as is, only users who can get "pods/log" see the logs
+func (h *funcinstHandler) streamPodLogs(apiContext *types.APIContext) error {
+	podName := apiContext.Query.Get("pod")
+	if podName == "" {
+		return httperror.NewFieldAPIError(httperror.MissingRequired, "pod", "")
+	}
+
+	fni, err := h.getFuncinst(apiContext)
+	if err != nil {
+		return err
+	}
+
+	// only pods of current funcinst are allowed
+	pods, err := h.executorPods(apiContext, fni)
+	if err != nil {
+		return err
+	}
+	found := false
+	for _, pod := range pods {
+		if pod.Name == podName {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return httperror.NewAPIError(httperror.NotFound, "pod "+podName+" is not an executor of "+fni.Name)
+	}
+
+	params := map[string]string{
+		"follow":    "true",
+		"tailLines": "100",
+	}
+	for _, key := range []string{"container", "tailLines"} {
+		if v := apiContext.Query.Get(key); v != "" {
+			params[key] = v
+		}
+	}
+	stream, err := h.pods.Stream(apiContext, fni.Namespace, podName, "log", params)
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+
+	done := make(chan struct{})
+	defer close(done)
+
+	lines := make(chan []byte)
+	go func() {
+		defer close(lines)
+		scanner := bufio.NewScanner(stream)
+		for scanner.Scan() {
+			select {
+			case lines <- append([]byte{}, scanner.Bytes()...):
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return streamWebsocket(apiContext, nil, lines)
+}
+
+func (h *funcinstHandler) executorPods(apiContext *types.APIContext, fni *rfv1.Funcinst) ([]corev1.Pod, error) {
+	pods := &corev1.PodList{}
+	selector := fmt.Sprintf("%s=%s,%s=%s", rfv1.LabelName, fni.Spec.FuncdefRef.Name, rfv1.LabelExecutor, fni.Name)
+	if err := h.pods.List(apiContext, fni.Namespace, selector, pods); err != nil {
+		return nil, err
+	}
+	return pods.Items, nil
+}
+
+func (h *funcinstHandler) getFuncinst(apiContext *types.APIContext) (*rfv1.Funcinst, error) {
+	namespace, name := splitID(apiContext.ID)
+	fni := &rfv1.Funcinst{}
+	if err := h.funcinsts.Get(apiContext, namespace, name, fni); err != nil {
+		return nil, err
+	}
+	if fni.Spec.FuncdefRef == nil {
+		return nil, httperror.NewAPIError(httperror.InvalidState, "funcinst has no funcdef")
+	}
+	return fni, nil
+}
+
+// tapFilter selects tapped frames to forward
+type tapFilter struct {
+	matches map[string]string
+	sample  float64
+}
+
+func newTapFilter(query url.Values) (*tapFilter, error) {
+	filter := &tapFilter{
+		matches: map[string]string{},
+		sample:  1,
+	}
+
+	for _, match := range query["filter"] {
+		parts := strings.SplitN(match, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, httperror.NewFieldAPIError(httperror.InvalidFormat, "filter", "filter must be <path>=<value>")
+		}
+		filter.matches[parts[0]] = parts[1]
+	}
+
+	if sample := query.Get("sample"); sample != "" {
+		rate, err := strconv.ParseFloat(sample, 64)
+		if err != nil || rate <= 0 || rate > 1 {
+			return nil, httperror.NewFieldAPIError(httperror.InvalidFormat, "sample", "sample must be a rate in (0, 1]")
+		}
+		filter.sample = rate
+	}
+
+	return filter, nil
+}
+
+// apply returns the frame as JSON if it is selected, otherwise nil
+func (f *tapFilter) apply(data []byte) []byte {
+	if f.sample < 1 && rand.Float64() >= f.sample {
+		return nil
+	}
+
+	if !json.Valid(data) {
+		// keep frames that cannot be decoded, as string
+		data, _ = json.Marshal(map[string]string{"raw": string(data)})
+	}
+
+	for path, value := range f.matches {
+		if gjson.GetBytes(data, path).String() != value {
+			return nil
+		}
+	}
+	return data
+}
+
+// permissionsDriftMapper fills permissionsDrift by comparing permissions with the default ones
+type permissionsDriftMapper struct {
+	ScopeRoot string
+}
+
+func (m permissionsDriftMapper) FromInternal(data map[string]interface{}) {
+	fni := &rfv1.Funcinst{}
+	fni.Name = convert.ToString(data["name"])
+	fni.Namespace = convert.ToString(data["namespaceId"])
+	if err := convert.ToObj(data["funcdefRef"], &fni.Spec.FuncdefRef); err != nil || fni.Spec.FuncdefRef == nil {
+		return
+	}
+	if err := convert.ToObj(values.GetValueN(data, "runtime", "permissions"), &fni.Spec.Runtime.Permissions); err != nil {
+		return
+	}
+
+	drift, err := convert.EncodeToMap(newPermissionsDrift(fni, m.ScopeRoot))
+	if err != nil {
+		return
+	}
+	data["permissionsDrift"] = drift
+}
+
+func (m permissionsDriftMapper) ToInternal(data map[string]interface{}) error {
+	values.RemoveValue(data, "permissionsDrift")
+	return nil
+}
+
+func (m permissionsDriftMapper) ModifySchema(schema *types.Schema, schemas *types.Schemas) error {
+	return nil
+}
+
+func newPermissionsDrift(fni *rfv1.Funcinst, scopeRoot string) *PermissionsDrift {
+	current, expected := fni.Spec.Runtime.Permissions, rfv1.NewDefaultPermissions(fni, scopeRootOf(fni, scopeRoot))
+
+	drift := &PermissionsDrift{}
+	if current.Scope != expected.Scope {
+		drift.Scope = expected.Scope
+	}
+	drift.PublishAdded, drift.PublishRemoved = diffSubjects(current.Publish, expected.Publish)
+	drift.SubscribeAdded, drift.SubscribeRemoved = diffSubjects(current.Subscribe, expected.Subscribe)
+	drift.Drifted = drift.Scope != "" ||
+		len(drift.PublishAdded)+len(drift.PublishRemoved)+len(drift.SubscribeAdded)+len(drift.SubscribeRemoved) > 0
+	return drift
+}
+
+// scopeRootOf returns configured scope root, or the one used by current scope of funcinst if not configured
+func scopeRootOf(fni *rfv1.Funcinst, scopeRoot string) string {
+	if scopeRoot != "" {
+		return scopeRoot
+	}
+	suffix := path.Join(fni.Spec.FuncdefRef.Namespace, fni.Spec.FuncdefRef.Name, "data") + "/"
+	return strings.TrimSuffix(strings.TrimSuffix(fni.Spec.Runtime.Permissions.Scope, suffix), "/")
+}
+
+// diffSubjects returns subjects only in current and subjects only in expected
+func diffSubjects(current, expected []string) (added, removed []string) {
+	inCurrent, inExpected := map[string]bool{}, map[string]bool{}
+	for _, subject := range current {
+		inCurrent[subject] = true
+	}
+	for _, subject := range expected {
+		inExpected[subject] = true
+		if !inCurrent[subject] {
+			removed = append(removed, subject)
+		}
+	}
+	for _, subject := range current {
+		if !inExpected[subject] {
+			added = append(added, subject)
+		}
+	}
+	return
+}
+
+// credentialsMapper masks credentials of a funcinst
+type credentialsMapper struct{}
+
+func (m credentialsMapper) FromInternal(data map[string]interface{}) {
+	for _, field := range []string{"accessKey", "secretKey", "token"} {
+		if v, ok := data[field]; ok && v != "" {
+			data[field] = maskedCredential
+		}
+	}
+}
+
+func (m credentialsMapper) ToInternal(data map[string]interface{}) error {
+	return nil
+}
+
+func (m credentialsMapper) ModifySchema(schema *types.Schema, schemas *types.Schemas) error {
+	return nil
+}
+
+// funcinstStatusMapper computes state from conditions of a funcinst,
+// FuncinstStatus.Conditions has no json tag thus cannot be read by statusMapper
+type funcinstStatusMapper struct{}
+
+func (m funcinstStatusMapper) FromInternal(data map[string]interface{}) {
+	status := rfv1.FuncinstStatus{}
+	if err := convert.ToObj(values.GetValueN(data, "status"), &status); err != nil {
+		return
+	}
+
+	state, transitioning, message := "idle", "no", ""
+	if _, c := findFuncinstCondition(&status, rfv1.FuncinstInactive); c != nil && c.Status == corev1.ConditionTrue {
+		state, message = "inactive", c.Message
+	} else if _, c := findFuncinstCondition(&status, rfv1.FuncinstPending); c != nil && c.Status == corev1.ConditionTrue {
+		state, transitioning, message = "pending", "yes", c.Message
+	} else if status.IsActiveCondition() {
+		state = "active"
+	}
+
+	if removed, ok := data["removed"]; ok && removed != nil && removed != "" {
+		state, transitioning = "removing", "yes"
+	}
+
+	data["state"] = state
+	data["transitioning"] = transitioning
+	data["transitioningMessage"] = message
+}
+
+func (m funcinstStatusMapper) ToInternal(data map[string]interface{}) error {
+	return nil
+}
+
+func (m funcinstStatusMapper) ModifySchema(schema *types.Schema, schemas *types.Schemas) error {
+	return nil
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+
+	rfv1 "git.v87.us/formicary/refunc/pkg/apis/refunc/v1"
+	"github.com/rancher/norman/httperror"
+	"github.com/rancher/norman/types"
+)
+
+func TestFuncinstStatusMapper(t *testing.T) {
+	condition := func(conditionType, status, message string) map[string]interface{} {
+		return map[string]interface{}{"type": conditionType, "status": status, "message": message}
+	}
+
+	cases := []struct {
+		name          string
+		conditions    []interface{}
+		removed       interface{}
+		state         string
+		transitioning string
+		message       string
+	}{
+		{name: "no conditions", state: "idle", transitioning: "no"},
+		{
+			name:          "created, never invoked",
+			conditions:    []interface{}{condition("Active", "False", "Created by function access")},
+			state:         "idle",
+			transitioning: "no",
+		},
+		{
+			name:          "active",
+			conditions:    []interface{}{condition("Active", "True", "")},
+			state:         "active",
+			transitioning: "no",
+		},
+		{
+			name:          "pending",
+			conditions:    []interface{}{condition("Active", "False", ""), condition("Pending", "True", "waiting for xenv python3")},
+			state:         "pending",
+			transitioning: "yes",
+			message:       "waiting for xenv python3",
+		},
+		{
+			name:          "inactive over pending and active",
+			conditions:    []interface{}{condition("Active", "True", ""), condition("Pending", "True", ""), condition("Inactive", "True", "Function was suspended")},
+			state:         "inactive",
+			transitioning: "no",
+			message:       "Function was suspended",
+		},
+		{
+			name:          "no longer inactive",
+			conditions:    []interface{}{condition("Inactive", "False", "Function was suspended"), condition("Active", "True", "")},
+			state:         "active",
+			transitioning: "no",
+		},
+		{
+			name:          "removing",
+			conditions:    []interface{}{condition("Active", "True", "")},
+			removed:       "2026-10-19T03:00:00Z",
+			state:         "removing",
+			transitioning: "yes",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			data := map[string]interface{}{
+				// conditions have no json tag
+				"status": map[string]interface{}{"Conditions": c.conditions},
+			}
+			if c.removed != nil {
+				data["removed"] = c.removed
+			}
+
+			funcinstStatusMapper{}.FromInternal(data)
+			if data["state"] != c.state || data["transitioning"] != c.transitioning || data["transitioningMessage"] != c.message {
+				t.Errorf("state = %v, %v, %q, want %v, %v, %q",
+					data["state"], data["transitioning"], data["transitioningMessage"], c.state, c.transitioning, c.message)
+			}
+		})
+	}
+}
+
+func TestTapFilter(t *testing.T) {
+	frame := `{"method":"POST","args":{"user":"alice","n":3}}`
+
+	cases := []struct {
+		name  string
+		query url.Values
+		frame string
+		want  string
+		err   bool
+	}{
+		{name: "no filter", frame: frame, want: frame},
+		{name: "match", query: url.Values{"filter": {"args.user=alice"}}, frame: frame, want: frame},
+		{name: "all matches", query: url.Values{"filter": {"args.user=alice", "method=POST"}}, frame: frame, want: frame},
+		{name: "one mismatch", query: url.Values{"filter": {"args.user=alice", "method=GET"}}, frame: frame},
+		{name: "number", query: url.Values{"filter": {"args.n=3"}}, frame: frame, want: frame},
+		{name: "missing path", query: url.Values{"filter": {"args.team=ops"}}, frame: frame},
+		{name: "empty value matches missing path", query: url.Values{"filter": {"args.team="}}, frame: frame, want: frame},
+		{name: "raw frame", frame: "plain text", want: `{"raw":"plain text"}`},
+		{name: "raw frame filtered", query: url.Values{"filter": {"raw=plain text"}}, frame: "plain text", want: `{"raw":"plain text"}`},
+		{name: "sample all", query: url.Values{"sample": {"1"}}, frame: frame, want: frame},
+		{name: "filter without value", query: url.Values{"filter": {"method"}}, err: true},
+		{name: "filter without path", query: url.Values{"filter": {"=POST"}}, err: true},
+		{name: "sample zero", query: url.Values{"sample": {"0"}}, err: true},
+		{name: "sample over one", query: url.Values{"sample": {"1.5"}}, err: true},
+		{name: "sample not a number", query: url.Values{"sample": {"half"}}, err: true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			filter, err := newTapFilter(c.query)
+			if c.err {
+				if err == nil {
+					t.Fatal("newTapFilter() succeeded, want error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := string(filter.apply([]byte(c.frame))); got != c.want {
+				t.Errorf("apply() = %q, want %q", got, c.want)
+			}
+		})
+	}
+}
+
+func TestTapFilterSample(t *testing.T) {
+	filter, err := newTapFilter(url.Values{"sample": {"0.25"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	forwarded := 0
+	for i := 0; i < 10000; i++ {
+		if filter.apply([]byte(`{}`)) != nil {
+			forwarded++
+		}
+	}
+	// far beyond the deviation of 10000 samples
+	if forwarded < 2000 || forwarded > 3000 {
+		t.Errorf("forwarded %d of 10000 frames at rate 0.25", forwarded)
+	}
+}
+
+func TestDiffSubjects(t *testing.T) {
+	cases := []struct {
+		name              string
+		current, expected []string
+		added, removed    []string
+	}{
+		{name: "both empty"},
+		{name: "same", current: []string{"a", "b"}, expected: []string{"b", "a"}},
+		{name: "only current", current: []string{"a", "b"}, added: []string{"a", "b"}},
+		{name: "only expected", expected: []string{"a", "b"}, removed: []string{"a", "b"}},
+		{
+			name:     "both ways",
+			current:  []string{"refunc.ns1.fn1", "_INBOX.>", "custom.>"},
+			expected: []string{"refunc.ns1.fn1", "refunc.ns1.fn1.>", "_INBOX.>"},
+			added:    []string{"custom.>"},
+			removed:  []string{"refunc.ns1.fn1.>"},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			added, removed := diffSubjects(c.current, c.expected)
+			if !reflect.DeepEqual(added, c.added) || !reflect.DeepEqual(removed, c.removed) {
+				t.Errorf("diffSubjects() = %v, %v, want %v, %v", added, removed, c.added, c.removed)
+			}
+		})
+	}
+}
+
+func TestResetPermissionsWithoutFuncdef(t *testing.T) {
+	const funcinstPath = "/apis/k8s.refunc.io/v1/namespaces/ns1/funcinsts/fi1"
+	server, clientGetter := newFakeAPIServer(t, map[string]map[string]interface{}{
+		funcinstPath: {
+			"apiVersion": "k8s.refunc.io/v1",
+			"kind":       "Funcinst",
+			"metadata":   map[string]interface{}{"name": "fi1", "namespace": "ns1", "resourceVersion": "1"},
+			"spec":       map[string]interface{}{"runtime": map[string]interface{}{"permissions": map[string]interface{}{"scope": "custom"}}},
+		},
+	})
+	defer server.Close()
+
+	h := &funcinstHandler{funcinsts: newCRDClient(clientGetter, rfv1.CRDs[3].CRD)}
+	apiContext := &types.APIContext{
+		ID:      "ns1:fi1",
+		Request: httptest.NewRequest(http.MethodPost, "/refunc/v1/funcinsts/ns1:fi1?action=resetPermissions", nil),
+	}
+	err := h.actionHandler("resetPermissions", nil, apiContext)
+	if apiError, ok := err.(*httperror.APIError); !ok || apiError.Code != httperror.InvalidState {
+		t.Fatalf("resetPermissions = %v, want InvalidState", err)
+	}
+	permissions := server.object(funcinstPath)["spec"].(map[string]interface{})["runtime"].(map[string]interface{})["permissions"]
+	if want := map[string]interface{}{"scope": "custom"}; !reflect.DeepEqual(permissions, want) {
+		t.Errorf("stored permissions = %v, want %v", permissions, want)
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"path"
 	"path/filepath"
 	"strings"
+	"time"
 
 	rfv1 "git.v87.us/formicary/refunc/pkg/apis/refunc/v1"
 	"github.com/rancher/norman/api"
@@ -29,11 +30,41 @@ import (
 // VERSION of current binary
 var VERSION = "v0.1.0-dev"
 
+// lines of logs kept for a funcinst, how long to keep them after last watcher left,
+// and how many funcinsts have their logs kept before anyone watches
+const (
+	logsBacklog  = 1000
+	logsLinger   = 10 * time.Minute
+	logsFollowed = 200
+)
+
+// how often idle funcinsts are reaped
+const reapInterval = 5 * time.Minute
+
+// condition transitions kept for a funcinst
+const timelineSize = 100
+
+// how long funcdeves listed to check functions of triggers are reused
+const funcdefNamesTTL = 10 * time.Second
+
+// upcoming fire times shown for a cron trigger
+const cronNextRuns = 5
+
+// how long to wait for an http trigger to respond to test action
+const triggerTestTimeout = 30 * time.Second
+
 func main() {
 	app := cli.NewApp()
 	app.Name = "refunc-rancher"
 	app.Version = VERSION
 	app.Usage = "You need help!"
+	app.Flags = []cli.Flag{
+		cli.StringFlag{
+			Name:   "shared-xenv-namespace",
+			Usage:  "namespace of xenvs shared by all namespaces, editable only by admins",
+			EnvVar: "REFUNC_SHARED_XENV_NAMESPACE",
+		},
+	}
 	app.Action = func(c *cli.Context) error {
 		ctx := context.Background()
 
@@ -59,6 +90,19 @@ func main() {
 			panic(err)
 		}
 
+		natsClient := newNatsClient(os.Getenv("NATS_ENDPOINT"))
+		// root of storage scope of funcinsts, guessed from existing scope if empty
+		scopeRoot := os.Getenv("REFUNC_SCOPE_ROOT")
+		// time zone used to show next runs of cron triggers
+		cronLocation, err := time.LoadLocation(os.Getenv("REFUNC_CRON_TIMEZONE"))
+		if err != nil {
+			panic(err)
+		}
+		// public url of refunc http gateway, used to build endpoints of http triggers
+		gatewayURL := os.Getenv("REFUNC_GATEWAY_URL")
+		// namespace of xenvs shared by all namespaces
+		sharedXenvNamespace := c.String("shared-xenv-namespace")
+
 		version := types.APIVersion{
 			Version: rfv1.SchemeGroupVersion.Version,
 			Group:   rfv1.SchemeGroupVersion.Group,
@@ -68,52 +112,191 @@ func main() {
 		schemas := newSchemas(&version)
 		subscribe.Register(&version, schemas)
 
+		// xenvtemplates
+		xenvTemplates := newXenvTemplateRegistry(newCoreClient(k8sClient, "configmaps"), os.Getenv("REFUNC_XENV_TEMPLATES_CONFIGMAP"))
+		// not a k8s object, so imported without default mappers
+		schemas.AddSchemas(types.NewSchemas().MustImportAndCustomize(&version, XenvTemplate{}, func(schema *types.Schema) {
+			schema.PluralName = "xenvtemplates"
+			schema.CollectionMethods = []string{http.MethodGet}
+			schema.ResourceMethods = []string{http.MethodGet}
+			schema.Store = &xenvTemplateStore{registry: xenvTemplates}
+		}))
+
+		// xenvs
+		xenvHandler := &xenvHandler{
+			sharedNamespace: sharedXenvNamespace,
+			templates:       xenvTemplates,
+			xenvs:           newCRDClient(k8sClient, rfv1.CRDs[1].CRD),
+			pods:            newCoreClient(k8sClient, "pods"),
+			secrets:         newCoreClient(k8sClient, "secrets"),
+			serviceAccounts: newCoreClient(k8sClient, "serviceaccounts"),
+			access:          newAccessReviewer(k8sClient),
+		}
+		schemas.MustImport(&version, XenvPod{})
+		schemas.MustImport(&version, XenvPoolStatus{})
+		schemas.MustImport(&version, XenvRotateKeyOutput{})
+		schemas.MustImport(&version, XenvCreateFromTemplateInput{})
+		schemas.AddMapperForType(&version, rfv1.XenvSpec{},
+			mapper.Move{From: "type", To: "xenvType"},
+			setupKeyMapper{},
+		).AddMapperForType(&version, rfv1.Xenv{},
+			sharedXenvMapper{Namespace: sharedXenvNamespace},
+		).MustImportAndCustomize(&version, rfv1.Xenv{}, func(schema *types.Schema) {
+			addStateFields(schema)
+			schema.ResourceActions = map[string]types.Action{
+				"rotateKey": {Output: "xenvRotateKeyOutput"},
+			}
+			schema.CollectionActions = map[string]types.Action{
+				"createFromTemplate": {Input: "xenvCreateFromTemplateInput", Output: "xenv"},
+			}
+			schema.Validator = xenvHandler.validator
+			schema.Formatter = xenvHandler.formatter
+			schema.CollectionFormatter = xenvHandler.collectionFormatter
+			schema.ActionHandler = xenvHandler.actionHandler
+			schema.DeleteHandler = xenvHandler.deleteHandler
+			schema.LinkHandler = xenvHandler.linkHandler
+			if err := assignStores(ctx, k8sClient, types.DefaultStorageContext, schema, rfv1.CRDs[1].CRD); err != nil {
+				panic(err)
+			}
+			schema.Store = &xenvStore{Store: schema.Store, sharedNamespace: sharedXenvNamespace, pods: xenvHandler.pods}
+		}, namespacedType, struct {
+			PoolStatus *XenvPoolStatus `json:"poolStatus" norman:"nocreate,noupdate"`
+			Global     bool            `json:"global" norman:"nocreate,noupdate"`
+		}{})
+
 		// funceves
+		fndHandler := &funcdefHandler{
+			funcdeves: newCRDClient(k8sClient, rfv1.CRDs[0].CRD),
+			funcinsts: newCRDClient(k8sClient, rfv1.CRDs[3].CRD),
+			triggers:  newCRDClient(k8sClient, rfv1.CRDs[2].CRD),
+			xenvs:     xenvHandler,
+		}
+		schemas.MustImport(&version, FuncdefDeleteReport{})
+		schemas.MustImport(&version, FuncdefSuspendInput{})
 		schemas.MustImport(&version, rfv1.FuncdefSpec{}, struct {
 			Meta map[string]interface{} `json:"meta"`
 		}{}).MustImportAndCustomize(&version, rfv1.Funcdef{}, func(schema *types.Schema) {
 			schema.PluralName = rfv1.FuncdefPluralName
+			schema.ResourceActions = map[string]types.Action{
+				"suspend": {Input: "funcdefSuspendInput"},
+				"resume":  {},
+			}
+			schema.Validator = fndHandler.validator
+			schema.Formatter = fndHandler.formatter
+			schema.ActionHandler = fndHandler.actionHandler
+			schema.DeleteHandler = fndHandler.deleteHandler
 			if err := assignStores(ctx, k8sClient, types.DefaultStorageContext, schema, rfv1.CRDs[0].CRD); err != nil {
 				panic(err)
 			}
 		}, namespacedType)
 
-		// xenvs
-		schemas.AddMapperForType(&version, rfv1.XenvSpec{},
-			mapper.Move{From: "type", To: "xenvType"},
-		).MustImportAndCustomize(&version, rfv1.Xenv{}, func(schema *types.Schema) {
-			if err := assignStores(ctx, k8sClient, types.DefaultStorageContext, schema, rfv1.CRDs[1].CRD); err != nil {
-				panic(err)
-			}
-		}, namespacedType)
+		// triggermiddlewares
+		middlewares := newMiddlewareRegistry(newCoreClient(k8sClient, "configmaps"), os.Getenv("REFUNC_MIDDLEWARES_CONFIGMAP"))
+		// not a k8s object, so imported without default mappers
+		schemas.AddSchemas(types.NewSchemas().MustImportAndCustomize(&version, TriggerMiddleware{}, func(schema *types.Schema) {
+			schema.PluralName = "triggermiddlewares"
+			schema.CollectionMethods = []string{http.MethodGet}
+			schema.ResourceMethods = []string{http.MethodGet}
+			schema.Store = &middlewareStore{registry: middlewares}
+		}))
 
 		// triggers
+		trgHandler := &triggerHandler{
+			location:    cronLocation,
+			triggers:    newCRDClient(k8sClient, rfv1.CRDs[2].CRD),
+			funcdeves:   newCRDClient(k8sClient, rfv1.CRDs[0].CRD),
+			middlewares: middlewares,
+			nats:        natsClient,
+			httpClient:  &http.Client{Timeout: triggerTestTimeout},
+			access:      newAccessReviewer(k8sClient),
+		}
+		schemas.MustImport(&version, TriggerTestInput{})
+		schemas.MustImport(&version, TriggerTestOutput{})
+		schemas.MustImport(&version, TriggerPreviewArgsInput{})
+		schemas.MustImport(&version, TriggerPreviewArgsOutput{})
+		schemas.MustImport(&version, TriggerRunNowInput{})
+		schemas.MustImport(&version, TriggerRunNowOutput{})
 		schemas.AddMapperForType(&version, rfv1.TriggerSpec{},
-			mapper.Enum{Field: "type", Options: []string{
-				"eventgateway",
-				"cron",
-				"http",
-			}},
+			mapper.Enum{Field: "type", Options: triggerTypes()},
 			mapper.Move{From: "type", To: "triggerType"},
+		).AddMapperForType(&version, rfv1.Trigger{},
+			triggerFuncdefMapper{},
+			triggerConfigMapper{},
+			cronNextRunsMapper{Location: cronLocation, Count: cronNextRuns},
+			httpEndpointMapper{GatewayURL: gatewayURL},
 		).MustImportAndCustomize(&version, rfv1.Trigger{}, func(schema *types.Schema) {
+			schema.ResourceActions = map[string]types.Action{
+				"test":        {Input: "triggerTestInput", Output: "triggerTestOutput"},
+				"previewArgs": {Input: "triggerPreviewArgsInput", Output: "triggerPreviewArgsOutput"},
+				"runNow":      {Input: "triggerRunNowInput", Output: "triggerRunNowOutput"},
+			}
+			schema.Validator = trgHandler.validator
+			schema.Formatter = trgHandler.formatter
+			schema.ActionHandler = trgHandler.actionHandler
 			if err := assignStores(ctx, k8sClient, types.DefaultStorageContext, schema, rfv1.CRDs[2].CRD); err != nil {
 				panic(err)
 			}
+			schema.Store = &triggerStore{Store: schema.Store, funcdeves: newFuncdefNames(trgHandler.funcdeves, funcdefNamesTTL)}
 		}, namespacedType)
 
 		// funcinsts
+		fniReaper := newReaper(newCoreClient(k8sClient, "namespaces"), newCRDClient(k8sClient, rfv1.CRDs[3].CRD))
+		go fniReaper.Run(ctx, reapInterval)
+		fniLogs := newTailHub(natsClient, logsBacklog, logsLinger)
+		go fniLogs.Follow(ctx, "refunc.*.*.logs.*", logsFollowed)
+		fniTimeline := newTimeline(newCRDClient(k8sClient, rfv1.CRDs[3].CRD), timelineSize, &suspender{
+			funcdeves: newCRDClient(k8sClient, rfv1.CRDs[0].CRD),
+			funcinsts: newCRDClient(k8sClient, rfv1.CRDs[3].CRD),
+		})
+		go fniTimeline.Run(ctx)
+
+		fniHandler := &funcinstHandler{
+			scopeRoot: scopeRoot,
+			funcinsts: newCRDClient(k8sClient, rfv1.CRDs[3].CRD),
+			pods:      newCoreClient(k8sClient, "pods"),
+			access:    newAccessReviewer(k8sClient),
+			nats:      natsClient,
+			logs:      fniLogs,
+			taps:      newTailHub(natsClient, 0, 0),
+			reaper:    fniReaper,
+			timeline:  fniTimeline,
+		}
+		schemas.MustImport(&version, FuncinstDeactivateInput{})
+		schemas.MustImport(&version, FuncinstPingInput{})
+		schemas.MustImport(&version, FuncinstPingOutput{})
+		schemas.MustImport(&version, FuncinstPod{})
+		schemas.MustImport(&version, FuncinstReapReport{})
+		schemas.MustImport(&version, FuncinstTransition{})
+		schemas.AddMapperForType(&version, rfv1.Credentials{}, credentialsMapper{})
 		schemas.AddMapperForType(&version, rfv1.Funcinst{},
 			labelToField{LabelField: "name", Field: "funcdefName"},
 			fillFundefIDField{},
+			funcinstStatusMapper{},
+			permissionsDriftMapper{ScopeRoot: scopeRoot},
 		).MustImportAndCustomize(&version, rfv1.Funcinst{}, func(schema *types.Schema) {
 			schema.CollectionMethods = []string{http.MethodGet}
 			schema.ResourceMethods = []string{http.MethodGet, http.MethodDelete}
+			schema.ResourceActions = map[string]types.Action{
+				"revealCredentials": {Output: "credentials"},
+				"deactivate":        {Input: "funcinstDeactivateInput"},
+				"reactivate":        {},
+				"ping":              {Input: "funcinstPingInput", Output: "funcinstPingOutput"},
+				"resetPermissions":  {},
+			}
+			schema.CollectionActions = map[string]types.Action{
+				"reapReport": {Output: "funcinstReapReport"},
+			}
+			schema.Formatter = fniHandler.formatter
+			schema.CollectionFormatter = fniHandler.collectionFormatter
+			schema.ActionHandler = fniHandler.actionHandler
+			schema.LinkHandler = fniHandler.linkHandler
 			if err := assignStores(ctx, k8sClient, types.DefaultStorageContext, schema, rfv1.CRDs[3].CRD); err != nil {
 				panic(err)
 			}
 		}, namespacedType, struct {
-			FuncdefName string `json:"funcdefName"`
-			FuncdefID   string `json:"funcdefId"`
+			FuncdefName      string            `json:"funcdefName"`
+			FuncdefID        string            `json:"funcdefId"`
+			PermissionsDrift *PermissionsDrift `json:"permissionsDrift" norman:"nocreate,noupdate"`
 		}{})
 
 		server := api.NewAPIServer()
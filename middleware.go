@@ -0,0 +1,119 @@
+package main
+
+import (
+	"sort"
+
+	"github.com/rancher/norman/httperror"
+	"github.com/rancher/norman/store/empty"
+	"github.com/rancher/norman/types"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// TriggerMiddleware is a middleware supported by event gateway
+type TriggerMiddleware struct {
+	types.Resource
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+// middlewareRegistry reads middlewares from a ConfigMap, in which keys are names and values are descriptions
+type middlewareRegistry struct {
+	configMaps *resourceClient
+	namespace  string
+	name       string
+}
+
+func newMiddlewareRegistry(configMaps *resourceClient, namespaceAndName string) *middlewareRegistry {
+	namespace, name := splitID(namespaceAndName)
+	return &middlewareRegistry{
+		configMaps: configMaps,
+		namespace:  namespace,
+		name:       name,
+	}
+}
+
+// Enabled returns false if no ConfigMap is configured, thus middlewares are not checked
+func (r *middlewareRegistry) Enabled() bool {
+	return r.name != ""
+}
+
+// Middlewares returns descriptions of middlewares by name
+func (r *middlewareRegistry) Middlewares() (map[string]string, error) {
+	if !r.Enabled() {
+		return map[string]string{}, nil
+	}
+
+	cm := &corev1.ConfigMap{}
+	if err := r.configMaps.Get(nil, r.namespace, r.name, cm); err != nil {
+		return nil, err
+	}
+	if cm.Data == nil {
+		return map[string]string{}, nil
+	}
+	return cm.Data, nil
+}
+
+// Check returns an error if any of names is not in registry
+func (r *middlewareRegistry) Check(names []string) error {
+	if !r.Enabled() || len(names) == 0 {
+		return nil
+	}
+
+	middlewares, err := r.Middlewares()
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if _, ok := middlewares[name]; !ok {
+			return httperror.NewFieldAPIError(httperror.InvalidOption, "middlewares", "unknown middleware "+name)
+		}
+	}
+	return nil
+}
+
+// middlewareStore serves triggerMiddlewares from registry
+type middlewareStore struct {
+	empty.Store
+
+	registry *middlewareRegistry
+}
+
+func (s *middlewareStore) ByID(apiContext *types.APIContext, schema *types.Schema, id string) (map[string]interface{}, error) {
+	middlewares, err := s.registry.Middlewares()
+	if err != nil {
+		return nil, err
+	}
+	description, ok := middlewares[id]
+	if !ok {
+		return nil, httperror.NewAPIError(httperror.NotFound, "middleware "+id+" not found")
+	}
+	return toMiddlewareData(schema, id, description), nil
+}
+
+func (s *middlewareStore) List(apiContext *types.APIContext, schema *types.Schema, opt *types.QueryOptions) ([]map[string]interface{}, error) {
+	middlewares, err := s.registry.Middlewares()
+	if err != nil {
+		return nil, err
+	}
+
+	names := []string{}
+	for name := range middlewares {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	result := []map[string]interface{}{}
+	for _, name := range names {
+		result = append(result, toMiddlewareData(schema, name, middlewares[name]))
+	}
+	return result, nil
+}
+
+func toMiddlewareData(schema *types.Schema, name, description string) map[string]interface{} {
+	return map[string]interface{}{
+		"id":          name,
+		"type":        schema.ID,
+		"name":        name,
+		"description": description,
+	}
+}
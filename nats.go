@@ -0,0 +1,304 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	nats "github.com/nats-io/go-nats"
+	"github.com/rancher/norman/httperror"
+	"github.com/sirupsen/logrus"
+)
+
+// natsClient connects to NATS on demand, so that API still works without NATS
+type natsClient struct {
+	sync.Mutex
+
+	url  string
+	conn *nats.Conn
+}
+
+func newNatsClient(url string) *natsClient {
+	if url == "" {
+		url = nats.DefaultURL
+	}
+	return &natsClient{url: url}
+}
+
+// Conn returns a connected *nats.Conn
+func (c *natsClient) Conn() (*nats.Conn, error) {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.conn != nil && !c.conn.IsClosed() {
+		return c.conn, nil
+	}
+
+	conn, err := nats.Connect(c.url, nats.Name("refunc-rancher"), nats.MaxReconnects(-1))
+	if err != nil {
+		return nil, httperror.WrapAPIError(err, httperror.ClusterUnavailable, "failed to connect to nats")
+	}
+	c.conn = conn
+	return conn, nil
+}
+
+// tailHub shares subscriptions of NATS subjects between watchers,
+// a subject is kept subscribed for a while after its last watcher left,
+// and the most recent messages are replayed to a new watcher
+type tailHub struct {
+	sync.Mutex
+
+	nats    *natsClient
+	backlog int
+	linger  time.Duration
+	tails   map[string]*subjectTail
+
+	// subjects tailed by Follow, least recently written at the back
+	pattern  string
+	followed *list.List
+	limit    int
+}
+
+func newTailHub(nc *natsClient, backlog int, linger time.Duration) *tailHub {
+	return &tailHub{
+		nats:     nc,
+		backlog:  backlog,
+		linger:   linger,
+		tails:    map[string]*subjectTail{},
+		followed: list.New(),
+	}
+}
+
+// Follow tails all subjects matching pattern with one subscription until ctx is done,
+// so that a subject has its backlog filled before anyone watches,
+// at most limit of them are kept, the least recently written idle ones are dropped first
+func (h *tailHub) Follow(ctx context.Context, pattern string, limit int) {
+	for {
+		conn, err := h.nats.Conn()
+		if err == nil {
+			var sub *nats.Subscription
+			if sub, err = conn.Subscribe(pattern, h.follow); err == nil {
+				h.Lock()
+				h.pattern, h.limit = pattern, limit
+				h.Unlock()
+				logrus.Debugf("following %s", pattern)
+
+				<-ctx.Done()
+				sub.Unsubscribe()
+				h.Lock()
+				h.pattern = ""
+				h.Unlock()
+				return
+			}
+		}
+		logrus.Warnf("failed to follow %s, %v", pattern, err)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(5 * time.Second):
+		}
+	}
+}
+
+func (h *tailHub) follow(msg *nats.Msg) {
+	h.Lock()
+	defer h.Unlock()
+
+	tail, ok := h.tails[msg.Subject]
+	if !ok {
+		tail = h.followTail(msg.Subject)
+	}
+	if tail.followed == nil {
+		// subscribed by its watchers before it was followed
+		return
+	}
+	h.followed.MoveToFront(tail.followed)
+	tail.add(msg.Data)
+}
+
+// followTail adds a tail of subject fed by Follow, must be called with lock held
+func (h *tailHub) followTail(subject string) *subjectTail {
+	tail := newSubjectTail(h.backlog)
+	tail.followed = h.followed.PushFront(subject)
+	h.tails[subject] = tail
+	h.evict()
+	return tail
+}
+
+// evict drops idle followed subjects over limit, must be called with lock held
+func (h *tailHub) evict() {
+	for e := h.followed.Back(); e != nil && h.followed.Len() > h.limit; {
+		subject, prev := e.Value.(string), e.Prev()
+		if h.tails[subject].idle() {
+			h.followed.Remove(e)
+			delete(h.tails, subject)
+		}
+		e = prev
+	}
+}
+
+// Watch returns recent messages of subject and a channel for upcoming ones,
+// the caller must call cancel when it is done
+func (h *tailHub) Watch(subject string) (backlog [][]byte, ch <-chan []byte, cancel func(), err error) {
+	h.Lock()
+	defer h.Unlock()
+
+	tail, err := h.tail(subject)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	c := make(chan []byte, 64)
+	backlog = tail.watch(c)
+
+	return backlog, c, func() {
+		h.Lock()
+		defer h.Unlock()
+		if tail.unwatch(c) == 0 {
+			h.release(subject, tail)
+		}
+	}, nil
+}
+
+// tail returns the tail of subject, subscribing it if needed, must be called with lock held
+func (h *tailHub) tail(subject string) (*subjectTail, error) {
+	if tail, ok := h.tails[subject]; ok {
+		return tail, nil
+	}
+	if h.pattern != "" && matchSubject(h.pattern, subject) {
+		return h.followTail(subject), nil
+	}
+
+	conn, err := h.nats.Conn()
+	if err != nil {
+		return nil, err
+	}
+	tail := newSubjectTail(h.backlog)
+	tail.sub, err = conn.Subscribe(subject, func(msg *nats.Msg) {
+		tail.add(msg.Data)
+	})
+	if err != nil {
+		return nil, httperror.WrapAPIError(err, httperror.ServerError, "failed to subscribe "+subject)
+	}
+	h.tails[subject] = tail
+	logrus.Debugf("tailing %s", subject)
+	return tail, nil
+}
+
+// matchSubject tells whether subject matches pattern with NATS wildcards "*" and ">"
+func matchSubject(pattern, subject string) bool {
+	patterns, tokens := strings.Split(pattern, "."), strings.Split(subject, ".")
+	for i, p := range patterns {
+		if p == ">" {
+			return len(tokens) > i
+		}
+		if i >= len(tokens) || (p != "*" && p != tokens[i]) {
+			return false
+		}
+	}
+	return len(patterns) == len(tokens)
+}
+
+// release unsubscribes subject after linger if it is still idle by then, must be called with lock held,
+// followed subjects are left to evict
+func (h *tailHub) release(subject string, tail *subjectTail) {
+	if tail.followed != nil {
+		h.evict()
+		return
+	}
+	if !tail.idle() {
+		return
+	}
+	tail.expire(h.linger, func() {
+		h.Lock()
+		defer h.Unlock()
+		if !tail.idle() || h.tails[subject] != tail {
+			return
+		}
+		delete(h.tails, subject)
+		tail.sub.Unsubscribe()
+		logrus.Debugf("stopped tailing %s", subject)
+	})
+}
+
+// subjectTail is a ring of most recent messages of a subject
+type subjectTail struct {
+	sync.Mutex
+
+	sub      *nats.Subscription
+	followed *list.Element
+	size     int
+	ring     [][]byte
+	next     int
+	watchers map[chan []byte]struct{}
+	timer    *time.Timer
+}
+
+func newSubjectTail(size int) *subjectTail {
+	return &subjectTail{
+		size:     size,
+		watchers: map[chan []byte]struct{}{},
+	}
+}
+
+func (t *subjectTail) add(data []byte) {
+	t.Lock()
+	defer t.Unlock()
+
+	// the ring grows as needed, most subjects never fill it
+	if len(t.ring) < t.size {
+		t.ring = append(t.ring, data)
+	} else if t.size > 0 {
+		t.ring[t.next] = data
+		t.next = (t.next + 1) % len(t.ring)
+	}
+	for c := range t.watchers {
+		select {
+		case c <- data:
+		default:
+			// drop messages for slow watchers rather than blocking others
+		}
+	}
+}
+
+func (t *subjectTail) watch(c chan []byte) [][]byte {
+	t.Lock()
+	defer t.Unlock()
+
+	if t.timer != nil {
+		t.timer.Stop()
+		t.timer = nil
+	}
+	t.watchers[c] = struct{}{}
+
+	backlog := [][]byte{}
+	for i := range t.ring {
+		backlog = append(backlog, t.ring[(t.next+i)%len(t.ring)])
+	}
+	return backlog
+}
+
+func (t *subjectTail) unwatch(c chan []byte) int {
+	t.Lock()
+	defer t.Unlock()
+	delete(t.watchers, c)
+	return len(t.watchers)
+}
+
+func (t *subjectTail) expire(after time.Duration, fn func()) {
+	t.Lock()
+	defer t.Unlock()
+	if t.timer != nil {
+		t.timer.Stop()
+	}
+	t.timer = time.AfterFunc(after, fn)
+}
+
+func (t *subjectTail) idle() bool {
+	t.Lock()
+	defer t.Unlock()
+	return len(t.watchers) == 0
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// testNats returns a client of NATS_ENDPOINT, or a local nats-server, skipping the test if none is running
+func testNats(t *testing.T) *natsClient {
+	nc := newNatsClient(os.Getenv("NATS_ENDPOINT"))
+	if _, err := nc.Conn(); err != nil {
+		t.Skipf("nats is not available, %v", err)
+	}
+	return nc
+}
+
+func publish(t *testing.T, nc *natsClient, subject string, lines ...string) {
+	conn, err := nc.Conn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range lines {
+		if err := conn.Publish(subject, []byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := conn.Flush(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func waitBacklog(t *testing.T, hub *tailHub, subject string, want []string) {
+	var got []string
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		backlog, _, cancel, err := hub.Watch(subject)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cancel()
+		got = []string{}
+		for _, data := range backlog {
+			got = append(got, string(data))
+		}
+		if reflect.DeepEqual(got, want) {
+			return
+		}
+	}
+	t.Fatalf("backlog of %s = %v, want %v", subject, got, want)
+}
+
+// follow starts following test subjects of hub, and waits until the subscription is live
+func follow(t *testing.T, nc *natsClient, hub *tailHub, prefix string, limit int) context.CancelFunc {
+	ctx, cancel := context.WithCancel(context.Background())
+	go hub.Follow(ctx, prefix+".*", limit)
+
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		publish(t, nc, prefix+".probe", "probe")
+		if tailed(hub, prefix+".probe") {
+			return cancel
+		}
+	}
+	cancel()
+	t.Fatalf("%s.* is not followed", prefix)
+	return nil
+}
+
+func TestTailHubFollow(t *testing.T) {
+	nc := testNats(t)
+	hub := newTailHub(nc, 3, time.Minute)
+	prefix := fmt.Sprintf("test.logs.%d", time.Now().UnixNano())
+	defer follow(t, nc, hub, prefix, 10)()
+
+	// lines before anyone watches are kept for late joiners, oldest first
+	publish(t, nc, prefix+".fn1", "1", "2", "3", "4", "5")
+	waitBacklog(t, hub, prefix+".fn1", []string{"3", "4", "5"})
+}
+
+func TestTailHubWatch(t *testing.T) {
+	nc := testNats(t)
+	hub := newTailHub(nc, 3, time.Minute)
+	subject := fmt.Sprintf("test.logs.%d", time.Now().UnixNano())
+
+	backlog, c, cancel, err := hub.Watch(subject)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cancel()
+	if len(backlog) != 0 {
+		t.Fatalf("backlog of new subject = %q, want empty", backlog)
+	}
+
+	publish(t, nc, subject, "hello")
+	select {
+	case data := <-c:
+		if string(data) != "hello" {
+			t.Fatalf("got %q, want %q", data, "hello")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timeout waiting for message")
+	}
+}
+
+func TestTailHubFollowLimit(t *testing.T) {
+	nc := testNats(t)
+	hub := newTailHub(nc, 3, time.Minute)
+	prefix := fmt.Sprintf("test.logs.%d", time.Now().UnixNano())
+	defer follow(t, nc, hub, prefix, 2)()
+
+	_, _, cancel, err := hub.Watch(prefix + ".probe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	publish(t, nc, prefix+".fn1", "1")
+	publish(t, nc, prefix+".fn2", "2")
+	waitBacklog(t, hub, prefix+".fn2", []string{"2"})
+
+	// fn1 is the least recently written idle subject, the watched one is kept over limit
+	if tailed(hub, prefix+".fn1") || !tailed(hub, prefix+".probe") {
+		t.Fatalf("tailed fn1 = %v, probe = %v, want only probe kept", tailed(hub, prefix+".fn1"), tailed(hub, prefix+".probe"))
+	}
+
+	cancel()
+	publish(t, nc, prefix+".fn3", "3")
+	waitBacklog(t, hub, prefix+".fn3", []string{"3"})
+	if tailed(hub, prefix+".probe") || !tailed(hub, prefix+".fn2") {
+		t.Fatalf("tailed probe = %v, fn2 = %v, want only fn2 kept", tailed(hub, prefix+".probe"), tailed(hub, prefix+".fn2"))
+	}
+}
+
+func TestMatchSubject(t *testing.T) {
+	cases := []struct {
+		pattern, subject string
+		want             bool
+	}{
+		{"refunc.*.*.logs.*", "refunc.ns1.fn1.logs.fn1-abc", true},
+		{"refunc.*.*.logs.*", "refunc.ns1.fn1.logs", false},
+		{"refunc.*.*.logs.*", "refunc.ns1.fn1.logs.fn1-abc.more", false},
+		{"refunc.*.*.logs.*", "refunc.ns1.fn1.events.fn1-abc", false},
+		{"refunc.>", "refunc.ns1.fn1", true},
+		{"refunc.>", "refunc", false},
+		{"refunc.ns1", "refunc.ns1", true},
+	}
+	for _, c := range cases {
+		if got := matchSubject(c.pattern, c.subject); got != c.want {
+			t.Errorf("matchSubject(%q, %q) = %v, want %v", c.pattern, c.subject, got, c.want)
+		}
+	}
+}
+
+func tailed(hub *tailHub, subject string) bool {
+	hub.Lock()
+	defer hub.Unlock()
+	_, ok := hub.tails[subject]
+	return ok
+}
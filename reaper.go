@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	rfv1 "git.v87.us/formicary/refunc/pkg/apis/refunc/v1"
+	"github.com/rancher/norman/types"
+	"github.com/rancher/norman/types/convert"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	k8stypes "k8s.io/apimachinery/pkg/types"
+)
+
+// labels on namespace to enable reaper
+const (
+	// how long a funcinst can stay idle, in format of time.ParseDuration
+	labelReapTTL = "refunc.io/reap-ttl"
+	// what to do with idle funcinsts, "deactivate"(default) or "delete"
+	labelReapAction = "refunc.io/reap-action"
+)
+
+// reason of inactive condition set by reaper
+const reasonReaped = "Reaped"
+
+// FuncinstReapReport lists funcinsts to be reaped
+type FuncinstReapReport struct {
+	Candidates []ReapCandidate `json:"candidates"`
+}
+
+// ReapCandidate is a funcinst to be reaped
+type ReapCandidate struct {
+	FuncinstID string `json:"funcinstId"`
+	Action     string `json:"action"`
+	Reason     string `json:"reason"`
+}
+
+// reaper deletes or deactivates idle funcinsts in namespaces labelled with labelReapTTL
+type reaper struct {
+	sync.Mutex
+
+	namespaces *resourceClient
+	funcinsts  *resourceClient
+	// the time a funcinst was first observed without active backends
+	inactiveSince map[k8stypes.UID]time.Time
+}
+
+type reapCandidate struct {
+	fni    *rfv1.Funcinst
+	action string
+	reason string
+}
+
+func newReaper(namespaces, funcinsts *resourceClient) *reaper {
+	return &reaper{
+		namespaces:    namespaces,
+		funcinsts:     funcinsts,
+		inactiveSince: map[k8stypes.UID]time.Time{},
+	}
+}
+
+// Run reaps funcinsts periodically until ctx is done
+func (r *reaper) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if err := r.reap(); err != nil {
+			logrus.Errorf("reaper: %v", err)
+		}
+	}
+}
+
+func (r *reaper) reap() error {
+	candidates, err := r.candidates(nil, true)
+	if err != nil {
+		return err
+	}
+
+	for _, c := range candidates {
+		fni := c.fni
+		logrus.Infof("reaper: %s %s/%s, %s", c.action, fni.Namespace, fni.Name, c.reason)
+		if c.action == "delete" {
+			err = r.funcinsts.Delete(nil, fni.Namespace, fni.Name)
+		} else {
+			fni.Status.SetInactiveCondition(reasonReaped, c.reason)
+			err = r.funcinsts.Update(nil, fni.Namespace, fni.Name, fni)
+		}
+		if err != nil {
+			logrus.Errorf("reaper: failed to %s %s/%s, %v", c.action, fni.Namespace, fni.Name, err)
+		}
+	}
+	return nil
+}
+
+// candidates finds idle funcinsts, inactiveSince is updated if observe is true
+func (r *reaper) candidates(apiContext *types.APIContext, observe bool) ([]reapCandidate, error) {
+	namespaces := &corev1.NamespaceList{}
+	if err := r.namespaces.List(apiContext, "", labelReapTTL, namespaces); err != nil {
+		return nil, err
+	}
+
+	r.Lock()
+	defer r.Unlock()
+
+	now := time.Now()
+	seen := map[k8stypes.UID]bool{}
+	candidates := []reapCandidate{}
+	for _, ns := range namespaces.Items {
+		ttl, err := time.ParseDuration(ns.Labels[labelReapTTL])
+		if err != nil || ttl <= 0 {
+			logrus.Warnf("reaper: invalid %s of namespace %s", labelReapTTL, ns.Name)
+			continue
+		}
+		action := "deactivate"
+		if ns.Labels[labelReapAction] == "delete" {
+			action = "delete"
+		}
+
+		fnis := &rfv1.FuncinstList{}
+		if err := r.funcinsts.List(apiContext, ns.Name, "", fnis); err != nil {
+			return nil, err
+		}
+		for i := range fnis.Items {
+			fni := &fnis.Items[i]
+			seen[fni.UID] = true
+
+			if fni.Status.Active > 0 {
+				if observe {
+					delete(r.inactiveSince, fni.UID)
+				}
+			} else if _, ok := r.inactiveSince[fni.UID]; !ok && observe {
+				r.inactiveSince[fni.UID] = now
+			}
+
+			if action == "deactivate" && fni.Status.IsInactiveCondition() {
+				continue
+			}
+
+			if reason := idleReason(now, ttl, fni.Status.DeepCopy().LastActivity(), r.inactiveSince[fni.UID]); reason != "" {
+				candidates = append(candidates, reapCandidate{fni, action, reason})
+			}
+		}
+	}
+
+	if observe {
+		// forget funcinsts that have gone
+		for uid := range r.inactiveSince {
+			if !seen[uid] {
+				delete(r.inactiveSince, uid)
+			}
+		}
+	}
+
+	return candidates, nil
+}
+
+// idleReason tells why a funcinst has been idle for longer than ttl, or returns empty if it has not,
+// inactiveSince is zero if the funcinst has active backends. A funcinst that scales to zero between
+// invocations is kept as long as its last activity is recent.
+func idleReason(now time.Time, ttl time.Duration, lastActivity, inactiveSince time.Time) string {
+	if !lastActivity.IsZero() {
+		if now.Sub(lastActivity) > ttl {
+			return fmt.Sprintf("no activity since %s", lastActivity.Format(time.RFC3339))
+		}
+		return ""
+	}
+	if !inactiveSince.IsZero() && now.Sub(inactiveSince) > ttl {
+		return fmt.Sprintf("no active backends since %s", inactiveSince.Format(time.RFC3339))
+	}
+	return ""
+}
+
+// reportHandler responses funcinsts would be reaped, without touching them
+func (r *reaper) reportHandler(apiContext *types.APIContext) error {
+	candidates, err := r.candidates(apiContext, false)
+	if err != nil {
+		return err
+	}
+
+	report := FuncinstReapReport{Candidates: []ReapCandidate{}}
+	for _, c := range candidates {
+		report.Candidates = append(report.Candidates, ReapCandidate{
+			FuncinstID: c.fni.Namespace + ":" + c.fni.Name,
+			Action:     c.action,
+			Reason:     c.reason,
+		})
+	}
+
+	data, err := convert.EncodeToMap(report)
+	if err != nil {
+		return err
+	}
+	data["type"] = "funcinstReapReport"
+	apiContext.WriteResponse(http.StatusOK, data)
+	return nil
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestIdleReason(t *testing.T) {
+	now := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	ttl := time.Hour
+	ago := func(d time.Duration) time.Time { return now.Add(-d) }
+
+	cases := []struct {
+		name          string
+		lastActivity  time.Time
+		inactiveSince time.Time
+		want          string
+	}{
+		{"never seen idle", time.Time{}, time.Time{}, ""},
+		{"active recently", ago(time.Minute), time.Time{}, ""},
+		{"no activity for longer than ttl", ago(2 * time.Hour), time.Time{}, "no activity since 2026-10-19T10:00:00Z"},
+		{"no activity exactly ttl", ago(ttl), time.Time{}, ""},
+		{"no backends for longer than ttl", time.Time{}, ago(2 * time.Hour), "no active backends since 2026-10-19T10:00:00Z"},
+		{"no backends shorter than ttl", time.Time{}, ago(time.Minute), ""},
+		// invoked regularly but scaled to zero in between
+		{"scaled to zero with recent activity", ago(time.Minute), ago(3 * time.Hour), ""},
+		{"scaled to zero without recent activity", ago(2 * time.Hour), ago(3 * time.Hour), "no activity since 2026-10-19T10:00:00Z"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := idleReason(now, ttl, c.lastActivity, c.inactiveSince); got != c.want {
+				t.Errorf("idleReason() = %q, want %q", got, c.want)
+			}
+		})
+	}
+}
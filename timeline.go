@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	rfv1 "git.v87.us/formicary/refunc/pkg/apis/refunc/v1"
+	"github.com/rancher/norman/types"
+	"github.com/rancher/norman/types/convert"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	k8stypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+// reason of transitions recorded for conditions removed from a funcinst
+const reasonConditionCleared = "ConditionCleared"
+
+// FuncinstTransition is a change of a funcinst condition
+type FuncinstTransition struct {
+	ConditionType string `json:"conditionType"`
+	Status        string `json:"status"`
+	Reason        string `json:"reason,omitempty"`
+	Message       string `json:"message,omitempty"`
+	Time          string `json:"time"`
+}
+
+// timeline watches funcinsts and keeps the most recent condition transitions of each funcinst,
+// since the CRD only stores the latest state of a condition
+type timeline struct {
+	sync.Mutex
+
+	funcinsts *resourceClient
+	size      int
+	histories map[string]*funcinstHistory
+	synced    bool
+	listeners []funcinstListener
+}
+
+// funcinstListener is notified of funcinsts created after timeline started, it is called without lock held
+type funcinstListener interface {
+	FuncinstAdded(fni *rfv1.Funcinst)
+}
+
+type funcinstHistory struct {
+	uid         k8stypes.UID
+	conditions  map[rfv1.FuncinstConditionType]rfv1.FuncinstCondition
+	transitions []FuncinstTransition
+}
+
+func newTimeline(funcinsts *resourceClient, size int, listeners ...funcinstListener) *timeline {
+	return &timeline{
+		funcinsts: funcinsts,
+		size:      size,
+		histories: map[string]*funcinstHistory{},
+		listeners: listeners,
+	}
+}
+
+// Run lists and watches funcinsts until ctx is done
+func (t *timeline) Run(ctx context.Context) {
+	for {
+		if err := t.sync(ctx); err != nil {
+			logrus.Warnf("timeline: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// sync relists funcinsts then follows changes until the watch is closed
+func (t *timeline) sync(ctx context.Context) error {
+	fnis := &rfv1.FuncinstList{}
+	if err := t.funcinsts.List(nil, "", "", fnis); err != nil {
+		return err
+	}
+
+	t.Lock()
+	seen, added := map[string]bool{}, []*rfv1.Funcinst{}
+	for i := range fnis.Items {
+		key := fnis.Items[i].Namespace + "/" + fnis.Items[i].Name
+		seen[key] = true
+		// created while the watch was down
+		if _, known := t.histories[key]; t.synced && !known {
+			added = append(added, &fnis.Items[i])
+		}
+		t.observe(&fnis.Items[i])
+	}
+	for key := range t.histories {
+		if !seen[key] {
+			delete(t.histories, key)
+		}
+	}
+	t.synced = true
+	t.Unlock()
+	t.notify(added...)
+
+	stream, err := t.funcinsts.Watch(nil, "", fnis.ResourceVersion)
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			stream.Close()
+		case <-done:
+		}
+	}()
+
+	decoder := json.NewDecoder(stream)
+	for {
+		var event struct {
+			Type   watch.EventType `json:"type"`
+			Object rfv1.Funcinst   `json:"object"`
+		}
+		if err := decoder.Decode(&event); err != nil {
+			return nil
+		}
+
+		fni := &event.Object
+		t.Lock()
+		switch event.Type {
+		case watch.Added, watch.Modified:
+			t.observe(fni)
+		case watch.Deleted:
+			delete(t.histories, fni.Namespace+"/"+fni.Name)
+		}
+		t.Unlock()
+		if event.Type == watch.Added {
+			t.notify(fni)
+		}
+	}
+}
+
+func (t *timeline) notify(fnis ...*rfv1.Funcinst) {
+	for _, fni := range fnis {
+		for _, listener := range t.listeners {
+			listener.FuncinstAdded(fni)
+		}
+	}
+}
+
+// observe records conditions of fni that have changed since last seen, must be called with lock held
+func (t *timeline) observe(fni *rfv1.Funcinst) {
+	key := fni.Namespace + "/" + fni.Name
+	history, ok := t.histories[key]
+	if !ok || history.uid != fni.UID {
+		history = &funcinstHistory{uid: fni.UID}
+		t.histories[key] = history
+	}
+
+	now := time.Now().Format(time.RFC3339)
+	conditions := map[rfv1.FuncinstConditionType]rfv1.FuncinstCondition{}
+	for _, c := range fni.Status.Conditions {
+		conditions[c.Type] = c
+		if last, ok := history.conditions[c.Type]; ok && last.Status == c.Status && last.Reason == c.Reason {
+			continue
+		}
+		tm := c.LastTransitionTime
+		if tm == "" {
+			tm = now
+		}
+		history.record(FuncinstTransition{
+			ConditionType: string(c.Type),
+			Status:        string(c.Status),
+			Reason:        c.Reason,
+			Message:       c.Message,
+			Time:          tm,
+		}, t.size)
+	}
+	for ct := range history.conditions {
+		if _, ok := conditions[ct]; !ok {
+			history.record(FuncinstTransition{
+				ConditionType: string(ct),
+				Status:        string(corev1.ConditionFalse),
+				Reason:        reasonConditionCleared,
+				Time:          now,
+			}, t.size)
+		}
+	}
+	history.conditions = conditions
+}
+
+func (h *funcinstHistory) record(transition FuncinstTransition, size int) {
+	h.transitions = append(h.transitions, transition)
+	if len(h.transitions) > size {
+		h.transitions = h.transitions[len(h.transitions)-size:]
+	}
+}
+
+// Transitions returns recorded transitions of a funcinst, oldest first
+func (t *timeline) Transitions(namespace, name string) []FuncinstTransition {
+	t.Lock()
+	defer t.Unlock()
+
+	transitions := []FuncinstTransition{}
+	if history, ok := t.histories[namespace+"/"+name]; ok {
+		transitions = append(transitions, history.transitions...)
+	}
+	return transitions
+}
+
+// listTimeline lists condition transitions of a funcinst
+func (h *funcinstHandler) listTimeline(apiContext *types.APIContext) error {
+	fni, err := h.getFuncinst(apiContext)
+	if err != nil {
+		return err
+	}
+
+	result := []map[string]interface{}{}
+	for _, transition := range h.timeline.Transitions(fni.Namespace, fni.Name) {
+		data, err := convert.EncodeToMap(transition)
+		if err != nil {
+			return err
+		}
+		data["type"] = "funcinstTransition"
+		result = append(result, data)
+	}
+
+	apiContext.WriteResponse(http.StatusOK, result)
+	return nil
+}
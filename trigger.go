@@ -0,0 +1,696 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	rfv1 "git.v87.us/formicary/refunc/pkg/apis/refunc/v1"
+	nats "github.com/nats-io/go-nats"
+	"github.com/rancher/norman/httperror"
+	"github.com/rancher/norman/types"
+	"github.com/rancher/norman/types/convert"
+	"github.com/rancher/norman/types/values"
+	"github.com/robfig/cron"
+	"github.com/sirupsen/logrus"
+	authorizationv1 "k8s.io/api/authorization/v1"
+)
+
+// config field of each trigger type supported by refunc operator,
+// a trigger must have exactly the config of its type
+var triggerConfigFields = map[string]string{
+	"eventgateway": "event",
+	"cron":         "cron",
+	"http":         "http",
+}
+
+// triggerTypes returns supported trigger types in a stable order
+func triggerTypes() []string {
+	names := []string{}
+	for name := range triggerConfigFields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// max size of response body returned by test action
+const triggerTestBodyLimit = 1 << 20
+
+// TriggerTestInput is input for test action
+type TriggerTestInput struct {
+	Method string `json:"method" norman:"default=POST,options=GET|POST|PUT"`
+	Body   string `json:"body"`
+}
+
+// TriggerTestOutput is the response of an http trigger to test action
+type TriggerTestOutput struct {
+	Status  int               `json:"status"`
+	Headers map[string]string `json:"headers"`
+	Body    string            `json:"body"`
+}
+
+// TriggerPreviewArgsInput is input for previewArgs action
+type TriggerPreviewArgsInput struct {
+	// fire time in RFC3339, next run of the trigger if empty
+	Time string `json:"time" norman:"type=date"`
+}
+
+// TriggerPreviewArgsOutput is args sent to function when a cron trigger fires at given time
+type TriggerPreviewArgsOutput struct {
+	Time string      `json:"time"`
+	Args interface{} `json:"args"`
+}
+
+// TriggerRunNowInput is input for runNow action
+type TriggerRunNowInput struct {
+	// seconds to wait for the function to return
+	Timeout int `json:"timeout" norman:"default=30,min=1,max=300"`
+}
+
+// TriggerRunNowOutput is result of invoking function of a cron trigger
+type TriggerRunNowOutput struct {
+	Time      string      `json:"time"`
+	Args      interface{} `json:"args"`
+	Result    interface{} `json:"result"`
+	LatencyMS int64       `json:"latencyMs"`
+}
+
+type triggerHandler struct {
+	location    *time.Location
+	triggers    *resourceClient
+	funcdeves   *resourceClient
+	middlewares *middlewareRegistry
+	nats        *natsClient
+	httpClient  *http.Client
+	access      *accessReviewer
+}
+
+func (h *triggerHandler) formatter(apiContext *types.APIContext, resource *types.RawResource) {
+	if _, ok := resource.Values["endpoint"]; ok {
+		resource.AddAction(apiContext, "test")
+	}
+	if resource.Values["triggerType"] == "cron" {
+		resource.AddAction(apiContext, "previewArgs")
+		resource.AddAction(apiContext, "runNow")
+	}
+}
+
+func (h *triggerHandler) actionHandler(actionName string, action *types.Action, apiContext *types.APIContext) error {
+	switch actionName {
+	case "test":
+		input := TriggerTestInput{}
+		if err := readInput(apiContext, action, &input); err != nil {
+			return err
+		}
+		return h.test(apiContext, input)
+	case "previewArgs":
+		input := TriggerPreviewArgsInput{}
+		if err := readInput(apiContext, action, &input); err != nil {
+			return err
+		}
+		return h.previewArgs(apiContext, input)
+	case "runNow":
+		input := TriggerRunNowInput{}
+		if err := readInput(apiContext, action, &input); err != nil {
+			return err
+		}
+		return h.runNow(apiContext, time.Duration(input.Timeout)*time.Second)
+	}
+	return httperror.NewAPIError(httperror.InvalidAction, "invalid action: "+actionName)
+}
+
+// previewArgs responses args of a cron trigger expanded for given fire time
+func (h *triggerHandler) previewArgs(apiContext *types.APIContext, input TriggerPreviewArgsInput) error {
+	trigger, err := h.getCronTrigger(apiContext)
+	if err != nil {
+		return err
+	}
+
+	var t time.Time
+	if input.Time != "" {
+		t, err = time.Parse(time.RFC3339, input.Time)
+		if err != nil {
+			return httperror.NewFieldAPIError(httperror.InvalidDateFormat, "time", err.Error())
+		}
+	} else {
+		schedule, err := cron.ParseStandard(trigger.Spec.Cron.Cron)
+		if err != nil {
+			return httperror.NewFieldAPIError(httperror.InvalidFormat, "cron", "invalid cron expression: "+err.Error())
+		}
+		t = schedule.Next(time.Now().In(h.location))
+	}
+
+	args, err := expandCronArgs(trigger, t)
+	if err != nil {
+		return err
+	}
+
+	data, err := convert.EncodeToMap(TriggerPreviewArgsOutput{
+		Time: t.Format(time.RFC3339),
+		Args: args,
+	})
+	if err != nil {
+		return err
+	}
+	data["type"] = "triggerPreviewArgsOutput"
+	apiContext.WriteResponse(http.StatusOK, data)
+	return nil
+}
+
+// runNow invokes function of a cron trigger with args expanded for now, as if the trigger fires,
+// for users who can create "triggers/run", since the function is invoked by the server
+func (h *triggerHandler) runNow(apiContext *types.APIContext, timeout time.Duration) error {
+	trigger, err := h.getCronTrigger(apiContext)
+	if err != nil {
+		return err
+	}
+	if err := h.access.Check(apiContext, authorizationv1.ResourceAttributes{
+		Namespace:   trigger.Namespace,
+		Verb:        "create",
+		Group:       rfv1.GroupName,
+		Resource:    rfv1.TriggerPluralName,
+		Subresource: "run",
+		Name:        trigger.Name,
+	}); err != nil {
+		return err
+	}
+
+	t := time.Now().In(h.location)
+	args, err := expandCronArgs(trigger, t)
+	if err != nil {
+		return err
+	}
+	request, err := json.Marshal(map[string]interface{}{"args": args})
+	if err != nil {
+		return err
+	}
+
+	conn, err := h.nats.Conn()
+	if err != nil {
+		return err
+	}
+	// request subject of a function
+	subject := fmt.Sprintf("refunc.%s.%s", trigger.Namespace, trigger.Spec.FuncName)
+	start := time.Now()
+	msg, err := conn.Request(subject, request, timeout)
+	if err == nats.ErrTimeout {
+		return httperror.NewAPIError(httperror.ClusterUnavailable, "function did not respond in "+timeout.String())
+	} else if err != nil {
+		return httperror.WrapAPIError(err, httperror.ServerError, "failed to invoke "+subject)
+	}
+
+	output := TriggerRunNowOutput{
+		Time:      t.Format(time.RFC3339),
+		Args:      args,
+		LatencyMS: int64(time.Since(start) / time.Millisecond),
+	}
+	if err := json.Unmarshal(msg.Data, &output.Result); err != nil {
+		output.Result = string(msg.Data)
+	}
+
+	data, err := convert.EncodeToMap(output)
+	if err != nil {
+		return err
+	}
+	data["type"] = "triggerRunNowOutput"
+	apiContext.WriteResponse(http.StatusOK, data)
+	return nil
+}
+
+func (h *triggerHandler) getCronTrigger(apiContext *types.APIContext) (*rfv1.Trigger, error) {
+	namespace, name := splitID(apiContext.ID)
+
+	trigger := &rfv1.Trigger{}
+	if err := h.triggers.Get(apiContext, namespace, name, trigger); err != nil {
+		return nil, err
+	}
+	if trigger.Spec.Type != "cron" || trigger.Spec.Cron == nil {
+		return nil, httperror.NewAPIError(httperror.InvalidAction, "not a cron trigger")
+	}
+	return trigger, nil
+}
+
+// expandCronArgs renders args of a cron trigger as they are sent to function when it fires at t,
+// like refunc operator the extra args "$time", fire time in RFC3339, and "$triggerName" are appended to args
+func expandCronArgs(trigger *rfv1.Trigger, t time.Time) (map[string]interface{}, error) {
+	args := map[string]interface{}{}
+	if trigger.Spec.Cron.Args != nil {
+		raw, err := trigger.Spec.Cron.Args.MarshalJSON()
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(raw, &args); err != nil {
+			return nil, httperror.NewFieldAPIError(httperror.InvalidFormat, "args", "args must be an object: "+err.Error())
+		}
+	}
+	args["$time"] = t.Format(time.RFC3339)
+	args["$triggerName"] = trigger.Name
+	return args, nil
+}
+
+// test sends a request to endpoint of an http trigger and responses with what it got
+func (h *triggerHandler) test(apiContext *types.APIContext, input TriggerTestInput) error {
+	data, err := apiContext.Schema.Store.ByID(apiContext, apiContext.Schema, apiContext.ID)
+	if err != nil {
+		return err
+	}
+	endpoint := convert.ToString(data["endpoint"])
+	if endpoint == "" {
+		return httperror.NewAPIError(httperror.InvalidAction, "trigger has no endpoint")
+	}
+
+	req, err := http.NewRequest(input.Method, endpoint, strings.NewReader(input.Body))
+	if err != nil {
+		return httperror.NewFieldAPIError(httperror.InvalidOption, "method", err.Error())
+	}
+	if contentType, ok := values.GetValue(data, "http", "contentType"); ok && contentType != "" {
+		req.Header.Set("Content-Type", convert.ToString(contentType))
+	}
+
+	res, err := h.httpClient.Do(req)
+	if err != nil {
+		return httperror.WrapAPIError(err, httperror.ClusterUnavailable, "failed to request "+endpoint)
+	}
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(io.LimitReader(res.Body, triggerTestBodyLimit))
+	if err != nil {
+		return httperror.WrapAPIError(err, httperror.ServerError, "failed to read response")
+	}
+
+	output := TriggerTestOutput{
+		Status:  res.StatusCode,
+		Headers: map[string]string{},
+		Body:    string(body),
+	}
+	for k := range res.Header {
+		output.Headers[k] = res.Header.Get(k)
+	}
+
+	result, err := convert.EncodeToMap(output)
+	if err != nil {
+		return err
+	}
+	result["type"] = "triggerTestOutput"
+	apiContext.WriteResponse(http.StatusOK, result)
+	return nil
+}
+
+// validator checks config of a trigger matches its type,
+// on update configs of other types left by older versions are dropped
+func (h *triggerHandler) validator(apiContext *types.APIContext, schema *types.Schema, data map[string]interface{}) error {
+	trigger, err := mergeExisting(apiContext, schema, data)
+	if err != nil {
+		return err
+	}
+
+	if err := h.validateFuncdef(apiContext, trigger, data); err != nil {
+		return err
+	}
+
+	triggerType := convert.ToString(trigger["triggerType"])
+	if triggerType == "" {
+		return httperror.NewFieldAPIError(httperror.MissingRequired, "triggerType", "")
+	}
+	configField, ok := triggerConfigFields[triggerType]
+	if !ok {
+		return httperror.NewFieldAPIError(httperror.InvalidOption, "triggerType", "unsupported trigger type "+triggerType)
+	}
+	if trigger[configField] == nil {
+		return httperror.NewFieldAPIError(httperror.MissingRequired, configField,
+			fmt.Sprintf("%s is required for %s trigger", configField, triggerType))
+	}
+	if triggerType == "eventgateway" {
+		if err := h.validateEvent(apiContext, trigger); err != nil {
+			return err
+		}
+	}
+	if triggerType == "cron" {
+		expr, _ := values.GetValue(trigger, "cron", "cron")
+		if _, err := cron.ParseStandard(convert.ToString(expr)); err != nil {
+			return httperror.NewFieldAPIError(httperror.InvalidFormat, "cron", "invalid cron expression: "+err.Error())
+		}
+	}
+
+	stored, err := h.storedConfigs(apiContext)
+	if err != nil {
+		return err
+	}
+	for _, field := range triggerConfigFields {
+		if field == configField {
+			continue
+		}
+		if data[field] != nil {
+			return httperror.NewFieldAPIError(httperror.InvalidOption, field,
+				fmt.Sprintf("%s is not allowed for %s trigger", field, triggerType))
+		}
+		if stored[field] != nil {
+			// nil is kept by update merge and clears the config
+			data[field] = nil
+		}
+	}
+	return nil
+}
+
+// storedConfigs returns configs of the trigger being updated as they are stored,
+// including those of other types hidden by triggerConfigMapper
+func (h *triggerHandler) storedConfigs(apiContext *types.APIContext) (map[string]interface{}, error) {
+	if apiContext.Method != http.MethodPut {
+		return map[string]interface{}{}, nil
+	}
+	namespace, name := splitID(apiContext.ID)
+	existing := &rfv1.Trigger{}
+	if err := h.triggers.Get(apiContext, namespace, name, existing); err != nil {
+		return nil, err
+	}
+	return convert.EncodeToMap(existing.Spec.TriggerConfig)
+}
+
+// validateFuncdef checks the function of a trigger exists in the same namespace,
+// funcdefId given in data is converted to funcName
+func (h *triggerHandler) validateFuncdef(apiContext *types.APIContext, trigger, data map[string]interface{}) error {
+	namespace := convert.ToString(trigger["namespaceId"])
+	funcName := convert.ToString(trigger["funcName"])
+	field := "funcName"
+	if funcdefID := convert.ToString(data["funcdefId"]); funcdefID != "" {
+		field = "funcdefId"
+		fndNamespace, fndName := splitID(funcdefID)
+		if namespace == "" {
+			// created in namespace of the function
+			namespace = fndNamespace
+			trigger["namespaceId"] = namespace
+		} else if fndNamespace != namespace {
+			return httperror.NewFieldAPIError(httperror.InvalidReference, "funcdefId", "funcdef must be in namespace "+namespace)
+		}
+		funcName = fndName
+		data["funcName"] = fndName
+	}
+	delete(data, "funcdefId")
+
+	if funcName == "" {
+		return httperror.NewFieldAPIError(httperror.MissingRequired, field, "")
+	}
+	if err := h.funcdeves.Get(apiContext, namespace, funcName, &rfv1.Funcdef{}); err != nil {
+		if apiError, ok := err.(*httperror.APIError); ok && apiError.Code.Status == http.StatusNotFound {
+			return httperror.NewFieldAPIError(httperror.InvalidReference, field, "funcdef "+namespace+":"+funcName+" not found")
+		}
+		return err
+	}
+	return nil
+}
+
+// validateEvent checks middlewares of an event trigger are supported, and its alias is unique in namespace
+func (h *triggerHandler) validateEvent(apiContext *types.APIContext, trigger map[string]interface{}) error {
+	event := convert.ToMapInterface(trigger["event"])
+	if err := h.middlewares.Check(convert.ToStringSlice(event["middlewares"])); err != nil {
+		return err
+	}
+
+	alias := convert.ToString(event["alias"])
+	if alias == "" {
+		return nil
+	}
+	namespace, name := convert.ToString(trigger["namespaceId"]), convert.ToString(trigger["name"])
+	triggers := &rfv1.TriggerList{}
+	if err := h.triggers.List(apiContext, namespace, "", triggers); err != nil {
+		return err
+	}
+	for _, other := range triggers.Items {
+		if other.Name != name && other.Spec.Event != nil && other.Spec.Event.Alias == alias {
+			return httperror.NewFieldAPIError(httperror.NotUnique, "alias", "alias "+alias+" is used by trigger "+other.Name)
+		}
+	}
+	return nil
+}
+
+// triggerStore puts triggers whose function has gone into warning state,
+// triggers whose funcdeves can not be listed, e.g. for lack of permission, are left as they are
+type triggerStore struct {
+	types.Store
+
+	funcdeves *funcdefNames
+}
+
+func (s *triggerStore) ByID(apiContext *types.APIContext, schema *types.Schema, id string) (map[string]interface{}, error) {
+	data, err := s.Store.ByID(apiContext, schema, id)
+	if err != nil {
+		return nil, err
+	}
+	s.checkFuncdeves(apiContext, []map[string]interface{}{data})
+	return data, nil
+}
+
+func (s *triggerStore) List(apiContext *types.APIContext, schema *types.Schema, opt *types.QueryOptions) ([]map[string]interface{}, error) {
+	list, err := s.Store.List(apiContext, schema, opt)
+	if err != nil {
+		return nil, err
+	}
+	s.checkFuncdeves(apiContext, list)
+	return list, nil
+}
+
+func (s *triggerStore) Watch(apiContext *types.APIContext, schema *types.Schema, opt *types.QueryOptions) (chan map[string]interface{}, error) {
+	c, err := s.Store.Watch(apiContext, schema, opt)
+	if err != nil {
+		return nil, err
+	}
+	return convert.Chan(c, func(data map[string]interface{}) map[string]interface{} {
+		if data[".removed"] != true {
+			s.checkFuncdeves(apiContext, []map[string]interface{}{data})
+		}
+		return data
+	}), nil
+}
+
+func (s *triggerStore) checkFuncdeves(apiContext *types.APIContext, triggers []map[string]interface{}) {
+	for _, data := range triggers {
+		namespace, funcName := convert.ToString(data["namespaceId"]), convert.ToString(data["funcName"])
+		names := s.funcdeves.Get(apiContext, namespace)
+		if names != nil && !names[funcName] && data["state"] != "error" {
+			data["state"] = "warning"
+			data["transitioning"] = "no"
+			data["transitioningMessage"] = "funcdef " + namespace + ":" + funcName + " not found"
+		}
+	}
+}
+
+// funcdefNames caches names of funcdeves in a namespace as seen by a user for a short while,
+// so that reading or watching triggers does not list funcdeves every time
+type funcdefNames struct {
+	sync.Mutex
+
+	funcdeves *resourceClient
+	ttl       time.Duration
+	entries   map[string]funcdefNamesEntry
+}
+
+type funcdefNamesEntry struct {
+	names   map[string]bool
+	expires time.Time
+}
+
+func newFuncdefNames(funcdeves *resourceClient, ttl time.Duration) *funcdefNames {
+	return &funcdefNames{
+		funcdeves: funcdeves,
+		ttl:       ttl,
+		entries:   map[string]funcdefNamesEntry{},
+	}
+}
+
+// Get returns names of funcdeves in namespace, or nil if current user can not list them
+func (c *funcdefNames) Get(apiContext *types.APIContext, namespace string) map[string]bool {
+	key := namespace
+	for _, header := range impersonateHeaders {
+		key += "\n" + strings.Join(apiContext.Request.Header[http.CanonicalHeaderKey(header)], ",")
+	}
+
+	now := time.Now()
+	c.Lock()
+	entry, ok := c.entries[key]
+	c.Unlock()
+	if ok && now.Before(entry.expires) {
+		return entry.names
+	}
+
+	entry = funcdefNamesEntry{expires: now.Add(c.ttl)}
+	fnds := &rfv1.FuncdefList{}
+	if err := c.funcdeves.List(apiContext, namespace, "", fnds); err != nil {
+		logrus.Debugf("failed to list funcdeves in %q, %v", namespace, err)
+	} else {
+		entry.names = map[string]bool{}
+		for _, fnd := range fnds.Items {
+			entry.names[fnd.Name] = true
+		}
+	}
+
+	c.Lock()
+	defer c.Unlock()
+	for k, e := range c.entries {
+		if !now.Before(e.expires) {
+			delete(c.entries, k)
+		}
+	}
+	c.entries[key] = entry
+	return entry.names
+}
+
+// triggerFuncdefMapper exposes function of a trigger as a reference
+type triggerFuncdefMapper struct{}
+
+func (m triggerFuncdefMapper) FromInternal(data map[string]interface{}) {
+	data["funcdefId"] = fmt.Sprintf("%s:%s", data["namespaceId"], data["funcName"])
+}
+
+func (m triggerFuncdefMapper) ToInternal(data map[string]interface{}) error {
+	// converted to funcName by validator
+	delete(data, "funcdefId")
+	return nil
+}
+
+func (m triggerFuncdefMapper) ModifySchema(schema *types.Schema, schemas *types.Schemas) error {
+	schema.ResourceFields["funcdefId"] = types.Field{
+		CodeName: "FuncdefID",
+		Type:     "reference[funcdef]",
+		Create:   true,
+		Update:   true,
+		Nullable: true,
+	}
+	return nil
+}
+
+// triggerConfigMapper shows only the config matching type of a trigger,
+// triggers without a valid config are put into error state until they are updated
+type triggerConfigMapper struct{}
+
+func (m triggerConfigMapper) FromInternal(data map[string]interface{}) {
+	state, transitioning, message := "active", "no", ""
+
+	triggerType := convert.ToString(data["triggerType"])
+	if configField, ok := triggerConfigFields[triggerType]; !ok {
+		state, transitioning, message = "error", "error", "unsupported trigger type "+triggerType
+	} else if data[configField] == nil {
+		state, transitioning, message = "error", "error", fmt.Sprintf("%s is required for %s trigger", configField, triggerType)
+	} else {
+		for _, field := range triggerConfigFields {
+			if field != configField {
+				delete(data, field)
+			}
+		}
+	}
+
+	data["state"] = state
+	data["transitioning"] = transitioning
+	data["transitioningMessage"] = message
+}
+
+func (m triggerConfigMapper) ToInternal(data map[string]interface{}) error {
+	return nil
+}
+
+func (m triggerConfigMapper) ModifySchema(schema *types.Schema, schemas *types.Schemas) error {
+	addStateFields(schema)
+	return nil
+}
+
+// addStateFields adds fields set by statusMapper to a schema without status
+func addStateFields(schema *types.Schema) {
+	schema.ResourceFields["state"] = types.Field{
+		CodeName: "State",
+		Type:     "string",
+	}
+	schema.ResourceFields["transitioning"] = types.Field{
+		CodeName: "Transitioning",
+		Type:     "enum",
+		Options: []string{
+			"yes",
+			"no",
+			"error",
+		},
+	}
+	schema.ResourceFields["transitioningMessage"] = types.Field{
+		CodeName: "TransitioningMessage",
+		Type:     "string",
+	}
+}
+
+// cronNextRunsMapper computes next fire times of cron triggers in given location,
+// triggers with invalid cron expression are put into error state
+type cronNextRunsMapper struct {
+	Location *time.Location
+	Count    int
+}
+
+func (m cronNextRunsMapper) FromInternal(data map[string]interface{}) {
+	if data["triggerType"] != "cron" {
+		return
+	}
+	expr, ok := values.GetValue(data, "cron", "cron")
+	if !ok {
+		return
+	}
+
+	schedule, err := cron.ParseStandard(convert.ToString(expr))
+	if err != nil {
+		data["state"] = "error"
+		data["transitioning"] = "error"
+		data["transitioningMessage"] = "invalid cron expression: " + err.Error()
+		return
+	}
+
+	nextRuns := []interface{}{}
+	t := time.Now().In(m.Location)
+	for i := 0; i < m.Count; i++ {
+		t = schedule.Next(t)
+		if t.IsZero() {
+			break
+		}
+		nextRuns = append(nextRuns, t.Format(time.RFC3339))
+	}
+	data["nextRuns"] = nextRuns
+}
+
+func (m cronNextRunsMapper) ToInternal(data map[string]interface{}) error {
+	delete(data, "nextRuns")
+	return nil
+}
+
+func (m cronNextRunsMapper) ModifySchema(schema *types.Schema, schemas *types.Schemas) error {
+	schema.ResourceFields["nextRuns"] = types.Field{
+		CodeName: "NextRuns",
+		Type:     "array[date]",
+	}
+	return nil
+}
+
+// httpEndpointMapper computes public url of http triggers,
+// requests to "<gateway>/<namespace>/<funcName>" are routed to the function by refunc gateway
+type httpEndpointMapper struct {
+	GatewayURL string
+}
+
+func (m httpEndpointMapper) FromInternal(data map[string]interface{}) {
+	if data["triggerType"] != "http" || m.GatewayURL == "" {
+		return
+	}
+	data["endpoint"] = fmt.Sprintf("%s/%s/%s", strings.TrimSuffix(m.GatewayURL, "/"), data["namespaceId"], data["funcName"])
+}
+
+func (m httpEndpointMapper) ToInternal(data map[string]interface{}) error {
+	delete(data, "endpoint")
+	return nil
+}
+
+func (m httpEndpointMapper) ModifySchema(schema *types.Schema, schemas *types.Schemas) error {
+	schema.ResourceFields["endpoint"] = types.Field{
+		CodeName: "Endpoint",
+		Type:     "string",
+	}
+	return nil
+}
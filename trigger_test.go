@@ -0,0 +1,335 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	rfv1 "git.v87.us/formicary/refunc/pkg/apis/refunc/v1"
+	"git.v87.us/pkg/bytesobj"
+	nats "github.com/nats-io/go-nats"
+	"github.com/rancher/norman/httperror"
+	"github.com/rancher/norman/types"
+	"github.com/rancher/norman/types/mapper"
+)
+
+func TestTriggerUpdateDropsStaleConfig(t *testing.T) {
+	const triggerPath = "/apis/k8s.refunc.io/v1/namespaces/ns1/triggers/tr1"
+	server, clientGetter := newFakeAPIServer(t, map[string]map[string]interface{}{
+		triggerPath: {
+			"apiVersion": "k8s.refunc.io/v1",
+			"kind":       "Trigger",
+			"metadata":   map[string]interface{}{"name": "tr1", "namespace": "ns1", "resourceVersion": "1"},
+			"spec": map[string]interface{}{
+				"funcName": "fn1",
+				"type":     "cron",
+				"cron":     map[string]interface{}{"cron": "*/5 * * * *"},
+				// left by an older version, hidden by triggerConfigMapper
+				"http": map[string]interface{}{"contentType": "text/plain"},
+			},
+		},
+		"/apis/k8s.refunc.io/v1/namespaces/ns1/funcdeves/fn1": {
+			"apiVersion": "k8s.refunc.io/v1",
+			"kind":       "Funcdef",
+			"metadata":   map[string]interface{}{"name": "fn1", "namespace": "ns1"},
+		},
+	})
+	defer server.Close()
+
+	version := types.APIVersion{
+		Version: rfv1.SchemeGroupVersion.Version,
+		Group:   rfv1.SchemeGroupVersion.Group,
+		Path:    "/refunc/v1",
+	}
+	h := &triggerHandler{
+		triggers:    newCRDClient(clientGetter, rfv1.CRDs[2].CRD),
+		funcdeves:   newCRDClient(clientGetter, rfv1.CRDs[0].CRD),
+		middlewares: newMiddlewareRegistry(nil, ""),
+	}
+	schemas := newSchemas(&version)
+	schemas.AddMapperForType(&version, rfv1.TriggerSpec{},
+		mapper.Enum{Field: "type", Options: triggerTypes()},
+		mapper.Move{From: "type", To: "triggerType"},
+	).AddMapperForType(&version, rfv1.Trigger{},
+		triggerFuncdefMapper{},
+		triggerConfigMapper{},
+	).MustImportAndCustomize(&version, rfv1.Trigger{}, func(schema *types.Schema) {
+		if err := assignStores(context.Background(), clientGetter, types.DefaultStorageContext, schema, rfv1.CRDs[2].CRD); err != nil {
+			t.Fatal(err)
+		}
+	}, namespacedType)
+	schema := schemas.Schema(&version, "trigger")
+
+	apiContext := &types.APIContext{
+		Method:  http.MethodPut,
+		ID:      "ns1:tr1",
+		Schema:  schema,
+		Schemas: schemas,
+		Request: httptest.NewRequest(http.MethodPut, "/refunc/v1/triggers/ns1:tr1", nil),
+	}
+	data := map[string]interface{}{
+		"triggerType": "eventgateway",
+		"event":       map[string]interface{}{"alias": "hello"},
+	}
+	if err := h.validator(apiContext, schema, data); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := schema.Store.Update(apiContext, schema, data, apiContext.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	spec := server.object(triggerPath)["spec"].(map[string]interface{})
+	if spec["type"] != "eventgateway" {
+		t.Errorf("stored type = %v, want eventgateway", spec["type"])
+	}
+	for _, field := range []string{"cron", "http"} {
+		if spec[field] != nil {
+			t.Errorf("stored %s = %v, want it dropped", field, spec[field])
+		}
+	}
+	if want := map[string]interface{}{"alias": "hello"}; !reflect.DeepEqual(spec["event"], want) {
+		t.Errorf("stored event = %v, want %v", spec["event"], want)
+	}
+}
+
+func TestTriggerConfigMapper(t *testing.T) {
+	cases := []struct {
+		name string
+		data map[string]interface{}
+		want map[string]interface{}
+	}{
+		{
+			name: "matching config",
+			data: map[string]interface{}{"triggerType": "cron", "cron": map[string]interface{}{"cron": "* * * * *"}},
+			want: map[string]interface{}{"triggerType": "cron", "cron": map[string]interface{}{"cron": "* * * * *"},
+				"state": "active", "transitioning": "no", "transitioningMessage": ""},
+		},
+		{
+			name: "configs of other types are hidden",
+			data: map[string]interface{}{"triggerType": "http",
+				"http": map[string]interface{}{}, "cron": map[string]interface{}{"cron": "* * * * *"}, "event": map[string]interface{}{}},
+			want: map[string]interface{}{"triggerType": "http", "http": map[string]interface{}{},
+				"state": "active", "transitioning": "no", "transitioningMessage": ""},
+		},
+		{
+			name: "missing config",
+			data: map[string]interface{}{"triggerType": "eventgateway", "cron": map[string]interface{}{"cron": "* * * * *"}},
+			want: map[string]interface{}{"triggerType": "eventgateway", "cron": map[string]interface{}{"cron": "* * * * *"},
+				"state": "error", "transitioning": "error", "transitioningMessage": "event is required for eventgateway trigger"},
+		},
+		{
+			name: "unsupported type",
+			data: map[string]interface{}{"triggerType": "kafka"},
+			want: map[string]interface{}{"triggerType": "kafka",
+				"state": "error", "transitioning": "error", "transitioningMessage": "unsupported trigger type kafka"},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			triggerConfigMapper{}.FromInternal(c.data)
+			if !reflect.DeepEqual(c.data, c.want) {
+				t.Errorf("FromInternal() = %v, want %v", c.data, c.want)
+			}
+		})
+	}
+}
+
+func TestExpandCronArgs(t *testing.T) {
+	at := time.Date(2026, 10, 19, 3, 0, 0, 0, time.FixedZone("CST", 8*3600))
+
+	cases := []struct {
+		name string
+		args string
+		want map[string]interface{}
+		err  bool
+	}{
+		{
+			name: "no args",
+			want: map[string]interface{}{"$time": "2026-10-19T03:00:00+08:00", "$triggerName": "nightly"},
+		},
+		{
+			name: "extra args are appended",
+			args: `{"table":"orders","days":[1,2]}`,
+			want: map[string]interface{}{"table": "orders", "days": []interface{}{1.0, 2.0},
+				"$time": "2026-10-19T03:00:00+08:00", "$triggerName": "nightly"},
+		},
+		{
+			name: "placeholders in values are kept",
+			args: `{"msg":"fired by $triggerName"}`,
+			want: map[string]interface{}{"msg": "fired by $triggerName",
+				"$time": "2026-10-19T03:00:00+08:00", "$triggerName": "nightly"},
+		},
+		{
+			name: "extra args override args of the same key",
+			args: `{"$time":"yesterday"}`,
+			want: map[string]interface{}{"$time": "2026-10-19T03:00:00+08:00", "$triggerName": "nightly"},
+		},
+		{
+			name: "args not an object",
+			args: `[1,2]`,
+			err:  true,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			trigger := &rfv1.Trigger{}
+			trigger.Name = "nightly"
+			trigger.Spec.Cron = &rfv1.CronTrigger{Cron: "0 3 * * *"}
+			if c.args != "" {
+				args, err := bytesobj.New([]byte(c.args))
+				if err != nil {
+					t.Fatal(err)
+				}
+				trigger.Spec.Cron.Args = args
+			}
+
+			got, err := expandCronArgs(trigger, at)
+			if c.err {
+				if err == nil {
+					t.Fatalf("expandCronArgs() = %v, want error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, c.want) {
+				t.Errorf("expandCronArgs() = %v, want %v", got, c.want)
+			}
+		})
+	}
+}
+
+// recordedResponse keeps what an action writes
+type recordedResponse struct {
+	code int
+	obj  interface{}
+}
+
+func (r *recordedResponse) Write(apiContext *types.APIContext, code int, obj interface{}) {
+	r.code, r.obj = code, obj
+}
+
+func TestTriggerRunNow(t *testing.T) {
+	nc := testNats(t)
+	conn, err := nc.Conn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	namespace := fmt.Sprintf("test%d", time.Now().UnixNano())
+
+	server, clientGetter := newFakeAPIServer(t, map[string]map[string]interface{}{
+		"/apis/k8s.refunc.io/v1/namespaces/" + namespace + "/triggers/nightly": {
+			"apiVersion": "k8s.refunc.io/v1",
+			"kind":       "Trigger",
+			"metadata":   map[string]interface{}{"name": "nightly", "namespace": namespace},
+			"spec": map[string]interface{}{
+				"funcName": "fn1",
+				"type":     "cron",
+				"cron":     map[string]interface{}{"cron": "0 3 * * *", "args": map[string]interface{}{"table": "orders"}},
+			},
+		},
+	})
+	defer server.Close()
+
+	// echoes args of the request
+	var invoked int32
+	sub, err := conn.Subscribe("refunc."+namespace+".fn1", func(msg *nats.Msg) {
+		atomic.AddInt32(&invoked, 1)
+		request := map[string]interface{}{}
+		json.Unmarshal(msg.Data, &request)
+		data, _ := json.Marshal(request["args"])
+		conn.Publish(msg.Reply, data)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Unsubscribe()
+
+	h := &triggerHandler{
+		location: time.UTC,
+		triggers: newCRDClient(clientGetter, rfv1.CRDs[2].CRD),
+		nats:     nc,
+		access:   newAccessReviewer(clientGetter),
+	}
+	request := httptest.NewRequest(http.MethodPost, "/refunc/v1/triggers/"+namespace+":nightly?action=runNow", nil)
+	request.Header.Set("Impersonate-User", "alice")
+	response := &recordedResponse{}
+	apiContext := &types.APIContext{
+		ID:             namespace + ":nightly",
+		Request:        request,
+		ResponseWriter: response,
+	}
+
+	server.Lock()
+	server.denied = true
+	server.Unlock()
+	err = h.runNow(apiContext, 2*time.Second)
+	if apiError, ok := err.(*httperror.APIError); !ok || apiError.Code != httperror.PermissionDenied {
+		t.Fatalf("runNow() without access = %v, want PermissionDenied", err)
+	}
+	if user, _ := server.user("/apis/authorization.k8s.io/v1/selfsubjectaccessreviews"); user != "alice" {
+		t.Errorf("access is reviewed as %q, want alice", user)
+	}
+	if n := atomic.LoadInt32(&invoked); n != 0 {
+		t.Fatalf("function is invoked %d times without access", n)
+	}
+
+	server.Lock()
+	server.denied = false
+	server.Unlock()
+	if err := h.runNow(apiContext, 2*time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	output := response.obj.(map[string]interface{})
+	result := output["result"].(map[string]interface{})
+	want := map[string]interface{}{"table": "orders", "$time": output["time"], "$triggerName": "nightly"}
+	if !reflect.DeepEqual(result, want) {
+		t.Errorf("function got args %v, want %v", result, want)
+	}
+}
+
+func TestFuncdefNamesCache(t *testing.T) {
+	const funcdefPath = "/apis/k8s.refunc.io/v1/namespaces/ns1/funcdeves/fn1"
+	server, clientGetter := newFakeAPIServer(t, map[string]map[string]interface{}{
+		funcdefPath: {
+			"apiVersion": "k8s.refunc.io/v1",
+			"kind":       "Funcdef",
+			"metadata":   map[string]interface{}{"name": "fn1", "namespace": "ns1"},
+		},
+	})
+	defer server.Close()
+
+	asUser := func(user string) *types.APIContext {
+		request := httptest.NewRequest(http.MethodGet, "/refunc/v1/triggers", nil)
+		request.Header.Set("Impersonate-User", user)
+		return &types.APIContext{Request: request}
+	}
+	cache := newFuncdefNames(newCRDClient(clientGetter, rfv1.CRDs[0].CRD), 200*time.Millisecond)
+	want := map[string]bool{"fn1": true}
+	if got := cache.Get(asUser("alice"), "ns1"); !reflect.DeepEqual(got, want) {
+		t.Fatalf("Get() = %v, want %v", got, want)
+	}
+
+	server.Lock()
+	delete(server.objects, funcdefPath)
+	server.Unlock()
+	// listed again only for another user or after ttl
+	if got := cache.Get(asUser("alice"), "ns1"); !reflect.DeepEqual(got, want) {
+		t.Errorf("cached Get() = %v, want %v", got, want)
+	}
+	if got := cache.Get(asUser("bob"), "ns1"); len(got) != 0 {
+		t.Errorf("Get() of another user = %v, want listed again", got)
+	}
+	time.Sleep(300 * time.Millisecond)
+	if got := cache.Get(asUser("alice"), "ns1"); len(got) != 0 {
+		t.Errorf("Get() after ttl = %v, want listed again", got)
+	}
+}
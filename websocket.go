@@ -0,0 +1,61 @@
+package main
+
+import (
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/rancher/norman/types"
+	"github.com/sirupsen/logrus"
+)
+
+var upgrader = websocket.Upgrader{}
+
+// streamWebsocket upgrades current request and sends backlog followed by messages from c,
+// as text frames, until c is closed or the client went away
+func streamWebsocket(apiContext *types.APIContext, backlog [][]byte, c <-chan []byte) error {
+	conn, err := upgrader.Upgrade(apiContext.Response, apiContext.Request, nil)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	for _, data := range backlog {
+		if err := conn.WriteMessage(websocket.TextMessage, data); err != nil {
+			logrus.Debugf("failed to write to websocket, %v", err)
+			return nil
+		}
+	}
+
+	t := time.NewTicker(5 * time.Second)
+	defer t.Stop()
+
+	// no point in ever returning error since the connection is hijacked
+	for {
+		select {
+		case data, ok := <-c:
+			if !ok {
+				return nil
+			}
+			if err := conn.WriteMessage(websocket.TextMessage, data); err != nil {
+				logrus.Debugf("failed to write to websocket, %v", err)
+				return nil
+			}
+		case <-t.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(time.Second)); err != nil {
+				return nil
+			}
+		case <-closed:
+			return nil
+		}
+	}
+}
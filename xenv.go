@@ -0,0 +1,586 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	rfv1 "git.v87.us/formicary/refunc/pkg/apis/refunc/v1"
+	"github.com/rancher/norman/httperror"
+	"github.com/rancher/norman/types"
+	"github.com/rancher/norman/types/convert"
+	"github.com/rancher/norman/types/values"
+	"github.com/sirupsen/logrus"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// annotation records the time the setup key of a xenv was last rotated
+const annotationKeyRotated = "refunc.io/key-rotated"
+
+// random bytes of a generated setup key
+const setupKeySize = 32
+
+// imageRef matches a well-formed image reference, i.e. [domain[:port]/]path[:tag][@digest],
+// simplified from the grammar of docker/distribution
+var imageRef = regexp.MustCompile(`^` +
+	`(?:(?:[a-zA-Z0-9]|[a-zA-Z0-9][a-zA-Z0-9-]*[a-zA-Z0-9])(?:\.(?:[a-zA-Z0-9]|[a-zA-Z0-9][a-zA-Z0-9-]*[a-zA-Z0-9]))*(?::[0-9]+)?/)?` +
+	`[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*(?:/[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*)*` +
+	`(?::[\w][\w.-]{0,127})?` +
+	`(?:@[A-Za-z][A-Za-z0-9]*(?:[-_+.][A-Za-z][A-Za-z0-9]*)*:[0-9a-fA-F]{32,})?` +
+	`$`)
+
+// XenvRotateKeyOutput is output of rotateKey action, the only place a generated key is shown
+type XenvRotateKeyOutput struct {
+	Key       string `json:"key"`
+	RotatedAt string `json:"rotatedAt"`
+}
+
+// XenvPoolStatus is status of pre-initialized runner pods of a xenv
+type XenvPoolStatus struct {
+	PoolSize int `json:"poolSize"`
+	Ready    int `json:"ready"`
+	// image of ready runners
+	Image   string    `json:"image"`
+	Failing []XenvPod `json:"failing"`
+}
+
+// XenvPod is a runner pod of a xenv
+type XenvPod struct {
+	Name     string `json:"name"`
+	NodeName string `json:"nodeName"`
+	Phase    string `json:"phase"`
+	Ready    bool   `json:"ready"`
+	Restarts int    `json:"restarts"`
+	Created  string `json:"created"`
+	Image    string `json:"image"`
+	// why the pod is failing, empty if it is not
+	Reason  string `json:"reason,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+type xenvHandler struct {
+	// namespace of xenvs shared by all namespaces, empty if disabled
+	sharedNamespace string
+
+	templates       *xenvTemplateRegistry
+	xenvs           *resourceClient
+	pods            *resourceClient
+	secrets         *resourceClient
+	serviceAccounts *resourceClient
+	access          *accessReviewer
+}
+
+func (h *xenvHandler) formatter(apiContext *types.APIContext, resource *types.RawResource) {
+	resource.Links["pods"] = apiContext.URLBuilder.Link("pods", resource)
+	resource.Links["funcdefs"] = apiContext.URLBuilder.Link("funcdefs", resource)
+	resource.AddAction(apiContext, "rotateKey")
+}
+
+func (h *xenvHandler) actionHandler(actionName string, action *types.Action, apiContext *types.APIContext) error {
+	switch actionName {
+	case "createFromTemplate":
+		return h.createFromTemplate(apiContext, action)
+	case "rotateKey":
+		namespace, _ := splitID(apiContext.ID)
+		if err := h.checkShared(apiContext, namespace); err != nil {
+			return err
+		}
+		return h.rotateKey(apiContext)
+	}
+	return httperror.NewAPIError(httperror.InvalidAction, "invalid action: "+actionName)
+}
+
+// validator checks a xenv, every invalid field is reported at once
+func (h *xenvHandler) validator(apiContext *types.APIContext, schema *types.Schema, data map[string]interface{}) error {
+	xenv, err := mergeExisting(apiContext, schema, data)
+	if err != nil {
+		return err
+	}
+	namespace := convert.ToString(xenv["namespaceId"])
+	if err := h.checkShared(apiContext, namespace); err != nil {
+		return err
+	}
+
+	errs := []error{}
+	container := corev1.Container{}
+	if err := convert.ToObj(xenv["container"], &container); err != nil {
+		errs = append(errs, httperror.NewFieldAPIError(httperror.InvalidFormat, "container", err.Error()))
+	} else if container.Image == "" {
+		errs = append(errs, httperror.NewFieldAPIError(httperror.MissingRequired, "container.image", ""))
+	} else if len(container.Image) > 255 || !imageRef.MatchString(container.Image) {
+		errs = append(errs, httperror.NewFieldAPIError(httperror.InvalidFormat, "container.image", "invalid image reference "+container.Image))
+	}
+
+	volumes := []corev1.Volume{}
+	if err := convert.ToObj(xenv["volumes"], &volumes); err != nil {
+		errs = append(errs, httperror.NewFieldAPIError(httperror.InvalidFormat, "volumes", err.Error()))
+	}
+	declared := map[string]bool{}
+	for _, volume := range volumes {
+		declared[volume.Name] = true
+	}
+	for _, mount := range container.VolumeMounts {
+		if !declared[mount.Name] {
+			errs = append(errs, httperror.NewFieldAPIError(httperror.InvalidReference, "container.volumeMounts",
+				fmt.Sprintf("volume %s mounted at %s is not declared in volumes", mount.Name, mount.MountPath)))
+		}
+	}
+
+	for name, request := range container.Resources.Requests {
+		if limit, ok := container.Resources.Limits[name]; ok && request.Cmp(limit) > 0 {
+			errs = append(errs, httperror.NewFieldAPIError(httperror.InvalidOption, "container.resources",
+				fmt.Sprintf("%s request %s exceeds limit %s", name, request.String(), limit.String())))
+		}
+	}
+
+	if namespace == "" {
+		// references cannot be checked before the namespace is known
+		return aggregateFieldErrors(errs)
+	}
+
+	secrets := []corev1.LocalObjectReference{}
+	if err := convert.ToObj(xenv["imagePullSecrets"], &secrets); err != nil {
+		errs = append(errs, httperror.NewFieldAPIError(httperror.InvalidFormat, "imagePullSecrets", err.Error()))
+	}
+	for _, secret := range secrets {
+		if err := checkExists(h.secrets, namespace, secret.Name, "imagePullSecrets", "secret"); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if serviceAccount := convert.ToString(xenv["serviceAccount"]); serviceAccount != "" {
+		if err := checkExists(h.serviceAccounts, namespace, serviceAccount, "serviceAccount", "service account"); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return aggregateFieldErrors(errs)
+}
+
+// checkExists returns InvalidReference on field if the object referred does not exist,
+// checked as the service account since users creating xenvs may not read the objects they refer to
+func checkExists(client *resourceClient, namespace, name, field, kind string) error {
+	if err := client.Get(nil, namespace, name, &map[string]interface{}{}); err != nil {
+		if apiError, ok := err.(*httperror.APIError); ok && apiError.Code.Status == http.StatusNotFound {
+			return httperror.NewFieldAPIError(httperror.InvalidReference, field, kind+" "+namespace+":"+name+" not found")
+		}
+		return err
+	}
+	return nil
+}
+
+// rotateKey replaces the setup key of a xenv with a generated one
+func (h *xenvHandler) rotateKey(apiContext *types.APIContext) error {
+	namespace, name := splitID(apiContext.ID)
+
+	xenv := &rfv1.Xenv{}
+	if err := h.xenvs.Get(apiContext, namespace, name, xenv); err != nil {
+		return err
+	}
+
+	key, err := generateSetupKey()
+	if err != nil {
+		return err
+	}
+	rotatedAt := time.Now().Format(time.RFC3339)
+	xenv.Spec.SetupKey = key
+	if xenv.Annotations == nil {
+		xenv.Annotations = map[string]string{}
+	}
+	xenv.Annotations[annotationKeyRotated] = rotatedAt
+	if err := h.xenvs.Update(apiContext, namespace, name, xenv); err != nil {
+		return err
+	}
+
+	data, err := convert.EncodeToMap(XenvRotateKeyOutput{Key: key, RotatedAt: rotatedAt})
+	if err != nil {
+		return err
+	}
+	data["type"] = "xenvRotateKeyOutput"
+	apiContext.WriteResponse(http.StatusOK, data)
+	return nil
+}
+
+func generateSetupKey() (string, error) {
+	buf := make([]byte, setupKeySize)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+func (h *xenvHandler) linkHandler(apiContext *types.APIContext, next types.RequestHandler) error {
+	switch apiContext.Link {
+	case "pods":
+		return h.listPods(apiContext)
+	case "funcdefs":
+		namespace, name := splitID(apiContext.ID)
+		funcdefs, err := h.dependents(apiContext, namespace, name)
+		if err != nil {
+			return err
+		}
+		apiContext.WriteResponse(http.StatusOK, funcdefs)
+		return nil
+	}
+	return httperror.NewAPIError(httperror.NotFound, "link not found")
+}
+
+// deleteHandler refuses to delete a xenv used by funcdefs unless "force=true" is given
+func (h *xenvHandler) deleteHandler(apiContext *types.APIContext, next types.RequestHandler) error {
+	namespace, name := splitID(apiContext.ID)
+	if err := h.checkShared(apiContext, namespace); err != nil {
+		return err
+	}
+	if apiContext.Query.Get("force") == "true" {
+		return next(apiContext, nil)
+	}
+
+	funcdefs, err := h.dependents(apiContext, namespace, name)
+	if err != nil {
+		return err
+	}
+	if len(funcdefs) > 0 {
+		ids := []string{}
+		for _, fnd := range funcdefs {
+			ids = append(ids, convert.ToString(fnd["id"]))
+		}
+		return httperror.NewAPIError(httperror.Conflict,
+			fmt.Sprintf("xenv %s is used by funcdefs %s, delete with force=true to delete anyway", name, strings.Join(ids, ", ")))
+	}
+	return next(apiContext, nil)
+}
+
+// dependents lists funcdefs whose runtime is the given xenv,
+// a shared xenv is used by funcdefs of every namespace without a xenv of the same name
+func (h *xenvHandler) dependents(apiContext *types.APIContext, namespace, name string) ([]map[string]interface{}, error) {
+	shared := namespace == h.sharedNamespace
+	opt := &types.QueryOptions{}
+	if !shared {
+		opt.Conditions = []*types.QueryCondition{types.EQ("namespaceId", namespace)}
+	}
+	schema := apiContext.Schemas.Schema(apiContext.Version, "funcdef")
+	list, err := schema.Store.List(apiContext, schema, opt)
+	if err != nil {
+		return nil, err
+	}
+
+	// whether namespaces have their own xenv of name
+	local := map[string]bool{namespace: true}
+	funcdefs := []map[string]interface{}{}
+	for _, fnd := range list {
+		if runtime, _ := values.GetValue(fnd, "runtime", "name"); convert.ToString(runtime) != name {
+			continue
+		}
+		fndNamespace := convert.ToString(fnd["namespaceId"])
+		if shared {
+			found, ok := local[fndNamespace]
+			if !ok {
+				found, err = h.exists(apiContext, fndNamespace, name)
+				if err != nil {
+					return nil, err
+				}
+				local[fndNamespace] = found
+			}
+			if found && fndNamespace != namespace {
+				continue
+			}
+		}
+		funcdefs = append(funcdefs, fnd)
+	}
+	return funcdefs, nil
+}
+
+// exists returns whether xenv of name exists in namespace
+func (h *xenvHandler) exists(apiContext *types.APIContext, namespace, name string) (bool, error) {
+	if err := h.xenvs.Get(apiContext, namespace, name, &rfv1.Xenv{}); err != nil {
+		if apiError, ok := err.(*httperror.APIError); ok && apiError.Code.Status == http.StatusNotFound {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// resolve returns namespace of the xenv a funcdef in namespace refers to by name,
+// its own xenv is preferred over the shared one, which is looked up as the service account
+// since users may use shared xenvs without reading them
+func (h *xenvHandler) resolve(apiContext *types.APIContext, namespace, name string) (string, error) {
+	found, err := h.exists(apiContext, namespace, name)
+	if err != nil {
+		return "", err
+	}
+	if found {
+		return namespace, nil
+	}
+
+	message := "xenv " + name + " not found in namespace " + namespace
+	if h.sharedNamespace != "" {
+		found, err := h.exists(nil, h.sharedNamespace, name)
+		if err != nil {
+			return "", err
+		}
+		if found {
+			return h.sharedNamespace, nil
+		}
+		message += " or shared namespace " + h.sharedNamespace
+	}
+	return "", httperror.NewFieldAPIError(httperror.InvalidReference, "runtime.name", message)
+}
+
+// checkShared returns PermissionDenied if namespace is the shared one and current user is not an admin,
+// i.e. one who can update xenvs of all namespaces
+func (h *xenvHandler) checkShared(apiContext *types.APIContext, namespace string) error {
+	if h.sharedNamespace == "" || namespace != h.sharedNamespace {
+		return nil
+	}
+	if err := h.access.Check(apiContext, authorizationv1.ResourceAttributes{
+		Verb:     "update",
+		Group:    rfv1.GroupName,
+		Resource: rfv1.XenvPluralName,
+	}); err != nil {
+		if apiError, ok := err.(*httperror.APIError); ok && apiError.Code.Status == http.StatusForbidden {
+			return httperror.NewAPIError(httperror.PermissionDenied, "shared xenvs can only be modified by admins")
+		}
+		return err
+	}
+	return nil
+}
+
+// listPods lists runner pods of a xenv
+func (h *xenvHandler) listPods(apiContext *types.APIContext) error {
+	namespace, name := splitID(apiContext.ID)
+
+	pods := &corev1.PodList{}
+	if err := h.pods.List(apiContext, namespace, rfv1.LabelRunner+"="+name, pods); err != nil {
+		return err
+	}
+
+	result := []map[string]interface{}{}
+	for i := range pods.Items {
+		data, err := convert.EncodeToMap(newXenvPod(&pods.Items[i]))
+		if err != nil {
+			return err
+		}
+		data["type"] = "xenvPod"
+		result = append(result, data)
+	}
+
+	apiContext.WriteResponse(http.StatusOK, result)
+	return nil
+}
+
+func newXenvPod(pod *corev1.Pod) XenvPod {
+	xp := XenvPod{
+		Name:     pod.Name,
+		NodeName: pod.Spec.NodeName,
+		Phase:    string(pod.Status.Phase),
+		Ready:    pod.Labels[rfv1.LabelRunnerIsReady] == "true",
+		Created:  pod.CreationTimestamp.Format(time.RFC3339),
+	}
+	if len(pod.Spec.Containers) > 0 {
+		xp.Image = pod.Spec.Containers[0].Image
+	}
+	for _, cs := range pod.Status.ContainerStatuses {
+		xp.Restarts += int(cs.RestartCount)
+		if waiting := cs.State.Waiting; waiting != nil && waiting.Reason != "" && waiting.Reason != "ContainerCreating" {
+			xp.Reason, xp.Message = waiting.Reason, waiting.Message
+		} else if terminated := cs.State.Terminated; terminated != nil && terminated.ExitCode != 0 {
+			xp.Reason, xp.Message = terminated.Reason, fmt.Sprintf("exited with %d: %s", terminated.ExitCode, terminated.Message)
+		}
+	}
+	if pod.Status.Phase == corev1.PodFailed && xp.Reason == "" {
+		xp.Reason, xp.Message = pod.Status.Reason, pod.Status.Message
+		if xp.Reason == "" {
+			xp.Reason = string(corev1.PodFailed)
+		}
+	}
+	return xp
+}
+
+// xenvStore fills pool status of xenvs from their runner pods,
+// and lists shared xenvs along with xenvs of a namespace
+type xenvStore struct {
+	types.Store
+
+	sharedNamespace string
+	pods            *resourceClient
+}
+
+func (s *xenvStore) ByID(apiContext *types.APIContext, schema *types.Schema, id string) (map[string]interface{}, error) {
+	data, err := s.Store.ByID(apiContext, schema, id)
+	if err != nil {
+		return nil, err
+	}
+	s.fillPoolStatus(apiContext, []map[string]interface{}{data})
+	return data, nil
+}
+
+func (s *xenvStore) Create(apiContext *types.APIContext, schema *types.Schema, data map[string]interface{}) (map[string]interface{}, error) {
+	data, err := s.Store.Create(apiContext, schema, data)
+	if err != nil {
+		return nil, err
+	}
+	s.fillPoolStatus(apiContext, []map[string]interface{}{data})
+	return data, nil
+}
+
+func (s *xenvStore) Update(apiContext *types.APIContext, schema *types.Schema, data map[string]interface{}, id string) (map[string]interface{}, error) {
+	data, err := s.Store.Update(apiContext, schema, data, id)
+	if err != nil {
+		return nil, err
+	}
+	s.fillPoolStatus(apiContext, []map[string]interface{}{data})
+	return data, nil
+}
+
+func (s *xenvStore) Watch(apiContext *types.APIContext, schema *types.Schema, opt *types.QueryOptions) (chan map[string]interface{}, error) {
+	c, err := s.Store.Watch(apiContext, schema, opt)
+	if err != nil {
+		return nil, err
+	}
+	return convert.Chan(c, func(data map[string]interface{}) map[string]interface{} {
+		if data[".removed"] != true {
+			s.fillPoolStatus(apiContext, []map[string]interface{}{data})
+		}
+		return data
+	}), nil
+}
+
+func (s *xenvStore) List(apiContext *types.APIContext, schema *types.Schema, opt *types.QueryOptions) ([]map[string]interface{}, error) {
+	list, err := s.Store.List(apiContext, schema, opt)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, condition := range opt.Conditions {
+		namespace := condition.Value
+		if s.sharedNamespace == "" || condition.Field != "namespaceId" ||
+			condition.ToCondition().Modifier != types.ModifierEQ || namespace == s.sharedNamespace {
+			continue
+		}
+
+		sharedOpt := &types.QueryOptions{Conditions: append([]*types.QueryCondition{}, opt.Conditions...)}
+		sharedOpt.Conditions[i] = types.EQ("namespaceId", s.sharedNamespace)
+		// namespace of sub context takes precedence over conditions
+		sharedContext := *apiContext
+		sharedContext.SubContext = nil
+		shared, err := s.Store.List(&sharedContext, schema, sharedOpt)
+		if err != nil {
+			// shared xenvs are not listed to those who cannot read them
+			logrus.Debugf("failed to list shared xenvs in %q, %v", s.sharedNamespace, err)
+			break
+		}
+		list = append(list, shared...)
+		// let shared xenvs pass the filter applied on results
+		opt.Conditions[i] = types.NewConditionFromString("namespaceId", types.ModifierIn, namespace, s.sharedNamespace)
+		break
+	}
+
+	s.fillPoolStatus(apiContext, list)
+	return list, nil
+}
+
+func (s *xenvStore) fillPoolStatus(apiContext *types.APIContext, xenvs []map[string]interface{}) {
+	// runner pods of each namespace by xenv, listed once
+	runners := map[string]map[string][]corev1.Pod{}
+	for _, data := range xenvs {
+		namespace, name := convert.ToString(data["namespaceId"]), convert.ToString(data["name"])
+		byXenv, ok := runners[namespace]
+		if !ok {
+			pods := &corev1.PodList{}
+			if err := s.pods.List(apiContext, namespace, rfv1.LabelRunner, pods); err == nil {
+				byXenv = map[string][]corev1.Pod{}
+				for _, pod := range pods.Items {
+					runner := pod.Labels[rfv1.LabelRunner]
+					byXenv[runner] = append(byXenv[runner], pod)
+				}
+			} else {
+				logrus.Debugf("failed to list runners in %q, %v", namespace, err)
+			}
+			runners[namespace] = byXenv
+		}
+		if byXenv == nil {
+			// pool status is not available to those who cannot list pods
+			continue
+		}
+
+		poolSize, _ := convert.ToNumber(data["poolSize"])
+		status := XenvPoolStatus{
+			PoolSize: int(poolSize),
+			Failing:  []XenvPod{},
+		}
+		images := map[string]int{}
+		for i := range byXenv[name] {
+			xp := newXenvPod(&byXenv[name][i])
+			if xp.Reason != "" {
+				status.Failing = append(status.Failing, xp)
+			} else if xp.Ready {
+				status.Ready++
+				images[xp.Image]++
+			}
+		}
+		for image, n := range images {
+			if n > images[status.Image] {
+				status.Image = image
+			}
+		}
+
+		state, transitioning, message := "active", "no", ""
+		if len(status.Failing) > 0 {
+			state, transitioning = "error", "error"
+			message = fmt.Sprintf("runner %s is failing, %s", status.Failing[0].Name, status.Failing[0].Reason)
+		} else if status.Ready < status.PoolSize {
+			state, transitioning = "pending", "yes"
+			message = fmt.Sprintf("%d of %d runners are ready", status.Ready, status.PoolSize)
+		}
+		data["state"] = state
+		data["transitioning"] = transitioning
+		data["transitioningMessage"] = message
+
+		if poolStatus, err := convert.EncodeToMap(status); err == nil {
+			data["poolStatus"] = poolStatus
+		}
+	}
+}
+
+// setupKeyMapper masks setup key of a xenv, the key can be set but is never shown
+type setupKeyMapper struct{}
+
+func (m setupKeyMapper) FromInternal(data map[string]interface{}) {
+	if v, ok := data["key"]; ok && v != "" {
+		data["key"] = maskedCredential
+	}
+}
+
+func (m setupKeyMapper) ToInternal(data map[string]interface{}) error {
+	// masked key sent back on update keeps the current key
+	if data["key"] == maskedCredential {
+		delete(data, "key")
+	}
+	return nil
+}
+
+func (m setupKeyMapper) ModifySchema(schema *types.Schema, schemas *types.Schemas) error {
+	return nil
+}
+
+// sharedXenvMapper marks xenvs in the shared namespace as global
+type sharedXenvMapper struct {
+	Namespace string
+}
+
+func (m sharedXenvMapper) FromInternal(data map[string]interface{}) {
+	data["global"] = m.Namespace != "" && data["namespaceId"] == m.Namespace
+}
+
+func (m sharedXenvMapper) ToInternal(data map[string]interface{}) error {
+	delete(data, "global")
+	return nil
+}
+
+func (m sharedXenvMapper) ModifySchema(schema *types.Schema, schemas *types.Schemas) error {
+	return nil
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	rfv1 "git.v87.us/formicary/refunc/pkg/apis/refunc/v1"
+	"github.com/rancher/norman/httperror"
+	"github.com/rancher/norman/store/empty"
+	"github.com/rancher/norman/types"
+)
+
+func TestImageRef(t *testing.T) {
+	cases := []struct {
+		image string
+		valid bool
+	}{
+		{"python", true},
+		{"python:3.7-alpine", true},
+		{"library/python:3", true},
+		{"refunc/python3-runner_v2", true},
+		{"registry.example.com/team/python:3", true},
+		{"localhost:5000/python", true},
+		{"registry-1.example.com:443/a/b/c:latest", true},
+		{"python@sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef", true},
+		{"python:3@sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef", true},
+		{"", false},
+		{"Python", false},
+		{"python:", false},
+		{"python:3 ", false},
+		{"-python", false},
+		{"python//3", false},
+		{"python:.3", false},
+		{"python@sha256:0123", false},
+		{"https://registry.example.com/python", false},
+	}
+	for _, c := range cases {
+		if got := imageRef.MatchString(c.image); got != c.valid {
+			t.Errorf("imageRef.MatchString(%q) = %v, want %v", c.image, got, c.valid)
+		}
+	}
+}
+
+func TestXenvValidatorReportsAllErrors(t *testing.T) {
+	const accountPath = "/api/v1/namespaces/ns1/serviceaccounts/runner"
+	server, clientGetter := newFakeAPIServer(t, map[string]map[string]interface{}{
+		accountPath: {
+			"apiVersion": "v1",
+			"kind":       "ServiceAccount",
+			"metadata":   map[string]interface{}{"name": "runner", "namespace": "ns1"},
+		},
+	})
+	defer server.Close()
+
+	h := &xenvHandler{
+		secrets:         newCoreClient(clientGetter, "secrets"),
+		serviceAccounts: newCoreClient(clientGetter, "serviceaccounts"),
+	}
+	request := httptest.NewRequest(http.MethodPost, "/refunc/v1/xenvs", nil)
+	request.Header.Set("Impersonate-User", "alice")
+	apiContext := &types.APIContext{Method: http.MethodPost, Request: request}
+	data := map[string]interface{}{
+		"namespaceId":      "ns1",
+		"container":        map[string]interface{}{"image": "Python:3"},
+		"imagePullSecrets": []interface{}{map[string]interface{}{"name": "regcred"}},
+		"serviceAccount":   "runner",
+	}
+
+	err := h.validator(apiContext, &types.Schema{}, data)
+	apiError, ok := err.(*httperror.APIError)
+	if !ok {
+		t.Fatalf("validator() = %v, want an api error", err)
+	}
+	want := "container.image: invalid image reference Python:3; imagePullSecrets: secret ns1:regcred not found"
+	if apiError.Code != httperror.InvalidFormat || apiError.Message != want {
+		t.Errorf("validator() = %s %q, want %s %q", apiError.Code, apiError.Message, httperror.InvalidFormat, want)
+	}
+	// references are checked as the service account
+	if user, ok := server.user(accountPath); !ok || user != "" {
+		t.Errorf("service account is read as %q, checked %v, want it read without impersonation", user, ok)
+	}
+}
+
+func TestXenvResolve(t *testing.T) {
+	const sharedPath = "/apis/k8s.refunc.io/v1/namespaces/refunc-shared/xenvs/golang"
+	xenv := func(namespace, name string) map[string]interface{} {
+		return map[string]interface{}{
+			"apiVersion": "k8s.refunc.io/v1",
+			"kind":       "Xenv",
+			"metadata":   map[string]interface{}{"name": name, "namespace": namespace},
+		}
+	}
+	server, clientGetter := newFakeAPIServer(t, map[string]map[string]interface{}{
+		"/apis/k8s.refunc.io/v1/namespaces/ns1/xenvs/python3": xenv("ns1", "python3"),
+		"/apis/k8s.refunc.io/v1/namespaces/ns1/xenvs/golang":  xenv("ns1", "golang"),
+		sharedPath: xenv("refunc-shared", "golang"),
+	})
+	defer server.Close()
+
+	h := &xenvHandler{
+		sharedNamespace: "refunc-shared",
+		xenvs:           newCRDClient(clientGetter, rfv1.CRDs[1].CRD),
+	}
+	request := httptest.NewRequest(http.MethodPost, "/refunc/v1/funcdeves", nil)
+	request.Header.Set("Impersonate-User", "alice")
+	apiContext := &types.APIContext{Method: http.MethodPost, Request: request}
+
+	cases := []struct {
+		namespace, name string
+		want            string
+		err             string
+	}{
+		{namespace: "ns1", name: "python3", want: "ns1"},
+		{namespace: "ns1", name: "golang", want: "ns1"},
+		{namespace: "ns2", name: "golang", want: "refunc-shared"},
+		{namespace: "ns2", name: "python3", err: "xenv python3 not found in namespace ns2 or shared namespace refunc-shared"},
+	}
+	for _, c := range cases {
+		got, err := h.resolve(apiContext, c.namespace, c.name)
+		if c.err != "" {
+			apiError, ok := err.(*httperror.APIError)
+			if !ok || apiError.Code != httperror.InvalidReference || apiError.FieldName != "runtime.name" || apiError.Message != c.err {
+				t.Errorf("resolve(%s, %s) = %v, want InvalidReference on runtime.name %q", c.namespace, c.name, err, c.err)
+			}
+			continue
+		}
+		if err != nil || got != c.want {
+			t.Errorf("resolve(%s, %s) = %q, %v, want %q", c.namespace, c.name, got, err, c.want)
+		}
+	}
+	// shared xenvs are looked up as the service account
+	if user, ok := server.user(sharedPath); !ok || user != "" {
+		t.Errorf("shared xenv is read as %q, checked %v, want it read without impersonation", user, ok)
+	}
+}
+
+// stubStore returns what is updated, and sends watch events given
+type stubStore struct {
+	empty.Store
+
+	events []map[string]interface{}
+}
+
+func (s *stubStore) Update(apiContext *types.APIContext, schema *types.Schema, data map[string]interface{}, id string) (map[string]interface{}, error) {
+	return data, nil
+}
+
+func (s *stubStore) Watch(apiContext *types.APIContext, schema *types.Schema, opt *types.QueryOptions) (chan map[string]interface{}, error) {
+	c := make(chan map[string]interface{}, len(s.events))
+	for _, event := range s.events {
+		c <- event
+	}
+	close(c)
+	return c, nil
+}
+
+func TestXenvStoreFillsPoolStatus(t *testing.T) {
+	server, clientGetter := newFakeAPIServer(t, map[string]map[string]interface{}{})
+	defer server.Close()
+
+	xenv := func() map[string]interface{} {
+		return map[string]interface{}{"namespaceId": "ns1", "name": "python3", "poolSize": 1}
+	}
+	removed := map[string]interface{}{"namespaceId": "ns1", "name": "golang", ".removed": true}
+	s := &xenvStore{
+		Store: &stubStore{events: []map[string]interface{}{xenv(), removed}},
+		pods:  newCoreClient(clientGetter, "pods"),
+	}
+	apiContext := &types.APIContext{Request: httptest.NewRequest(http.MethodPut, "/refunc/v1/xenvs/ns1:python3", nil)}
+
+	check := func(op string, data map[string]interface{}) {
+		if data["state"] != "pending" || data["transitioning"] != "yes" || data["transitioningMessage"] != "0 of 1 runners are ready" || data["poolStatus"] == nil {
+			t.Errorf("%s = %v, want pending pool status", op, data)
+		}
+	}
+
+	data, err := s.Update(apiContext, nil, xenv(), "ns1:python3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	check("Update()", data)
+
+	c, err := s.Watch(apiContext, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check("watched change", <-c)
+	if data := <-c; data["poolStatus"] != nil {
+		t.Errorf("watched removal = %v, want it left as is", data)
+	}
+}
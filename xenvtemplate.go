@@ -0,0 +1,245 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+
+	rfv1 "git.v87.us/formicary/refunc/pkg/apis/refunc/v1"
+	"github.com/ghodss/yaml"
+	"github.com/rancher/norman/httperror"
+	"github.com/rancher/norman/store/empty"
+	"github.com/rancher/norman/types"
+	"github.com/rancher/norman/types/convert"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// XenvTemplate is a ready-made xenv that new xenvs can be created from
+type XenvTemplate struct {
+	types.Resource
+	Name             string                        `json:"name"`
+	Description      string                        `json:"description"`
+	XenvType         string                        `json:"xenvType"`
+	Container        corev1.Container              `json:"container"`
+	Volumes          []corev1.Volume               `json:"volumes"`
+	ImagePullSecrets []corev1.LocalObjectReference `json:"imagePullSecrets"`
+	PoolSize         int                           `json:"poolSize"`
+	ServiceAccount   string                        `json:"serviceAccount"`
+}
+
+// XenvCreateFromTemplateInput is input for createFromTemplate action
+type XenvCreateFromTemplateInput struct {
+	TemplateID  string `json:"templateId" norman:"required"`
+	NamespaceID string `json:"namespaceId" norman:"required"`
+	Name        string `json:"name" norman:"required"`
+}
+
+// xenvTemplateSpec is a template in catalog, written as spec of a xenv with a description
+type xenvTemplateSpec struct {
+	Description string `json:"description"`
+	rfv1.XenvSpec
+}
+
+// defaultXenvTemplates is the built-in catalog, written like values of the ConfigMap
+var defaultXenvTemplates = map[string]string{
+	"python3.7": `
+description: Python 3.7 runtime
+type: agent
+poolSize: 1
+container:
+  name: executor
+  image: refunc/lambda:python3.7
+`,
+	"nodejs8.10": `
+description: Node.js 8.10 runtime
+type: agent
+poolSize: 1
+container:
+  name: executor
+  image: refunc/lambda:nodejs8.10
+`,
+	"go1.x": `
+description: Go 1.x runtime
+type: agent
+poolSize: 1
+container:
+  name: executor
+  image: refunc/lambda:go1.x
+`,
+}
+
+// xenvTemplateRegistry serves the built-in catalog with templates from a ConfigMap put onto it,
+// in which keys are names and values are templates in yaml
+type xenvTemplateRegistry struct {
+	configMaps *resourceClient
+	namespace  string
+	name       string
+}
+
+func newXenvTemplateRegistry(configMaps *resourceClient, namespaceAndName string) *xenvTemplateRegistry {
+	namespace, name := splitID(namespaceAndName)
+	return &xenvTemplateRegistry{
+		configMaps: configMaps,
+		namespace:  namespace,
+		name:       name,
+	}
+}
+
+// Templates returns templates by name, templates of the ConfigMap override built-in ones of the same name,
+// malformed templates are skipped
+func (r *xenvTemplateRegistry) Templates() (map[string]xenvTemplateSpec, error) {
+	templates := map[string]xenvTemplateSpec{}
+	addXenvTemplates(templates, defaultXenvTemplates)
+	if r.name == "" {
+		return templates, nil
+	}
+
+	cm := &corev1.ConfigMap{}
+	if err := r.configMaps.Get(nil, r.namespace, r.name, cm); err != nil {
+		if apiError, ok := err.(*httperror.APIError); ok && apiError.Code.Status == http.StatusNotFound {
+			logrus.Debugf("xenv templates %s:%s not found, only built-in ones are served", r.namespace, r.name)
+			return templates, nil
+		}
+		return nil, err
+	}
+	addXenvTemplates(templates, cm.Data)
+	return templates, nil
+}
+
+func addXenvTemplates(templates map[string]xenvTemplateSpec, data map[string]string) {
+	for name, raw := range data {
+		template := xenvTemplateSpec{}
+		if err := yaml.Unmarshal([]byte(raw), &template); err != nil {
+			logrus.Warnf("skip malformed xenv template %q, %v", name, err)
+			continue
+		}
+		templates[name] = template
+	}
+}
+
+// Template returns the template of name, or NotFound
+func (r *xenvTemplateRegistry) Template(name string) (xenvTemplateSpec, error) {
+	templates, err := r.Templates()
+	if err != nil {
+		return xenvTemplateSpec{}, err
+	}
+	template, ok := templates[name]
+	if !ok {
+		return xenvTemplateSpec{}, httperror.NewAPIError(httperror.NotFound, "xenv template "+name+" not found")
+	}
+	return template, nil
+}
+
+// xenvTemplateStore serves xenvTemplates from registry
+type xenvTemplateStore struct {
+	empty.Store
+
+	registry *xenvTemplateRegistry
+}
+
+func (s *xenvTemplateStore) ByID(apiContext *types.APIContext, schema *types.Schema, id string) (map[string]interface{}, error) {
+	template, err := s.registry.Template(id)
+	if err != nil {
+		return nil, err
+	}
+	return toXenvTemplateData(schema, id, template)
+}
+
+func (s *xenvTemplateStore) List(apiContext *types.APIContext, schema *types.Schema, opt *types.QueryOptions) ([]map[string]interface{}, error) {
+	templates, err := s.registry.Templates()
+	if err != nil {
+		return nil, err
+	}
+
+	names := []string{}
+	for name := range templates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	result := []map[string]interface{}{}
+	for _, name := range names {
+		data, err := toXenvTemplateData(schema, name, templates[name])
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, data)
+	}
+	return result, nil
+}
+
+func toXenvTemplateData(schema *types.Schema, name string, template xenvTemplateSpec) (map[string]interface{}, error) {
+	data, err := convert.EncodeToMap(XenvTemplate{
+		Resource:         types.Resource{ID: name, Type: schema.ID},
+		Name:             name,
+		Description:      template.Description,
+		XenvType:         template.Type,
+		Container:        template.Container,
+		Volumes:          template.Volumes,
+		ImagePullSecrets: template.ImagePullSecrets,
+		PoolSize:         template.PoolSize,
+		ServiceAccount:   template.ServiceAccount,
+	})
+	if err != nil {
+		return nil, err
+	}
+	// filled by api server
+	delete(data, "links")
+	delete(data, "actions")
+	return data, nil
+}
+
+func (h *xenvHandler) collectionFormatter(apiContext *types.APIContext, collection *types.GenericCollection) {
+	collection.AddAction(apiContext, "createFromTemplate")
+}
+
+// createFromTemplate creates a xenv from a template with a generated setup key,
+// which is shown only in the response, like the one of rotateKey
+func (h *xenvHandler) createFromTemplate(apiContext *types.APIContext, action *types.Action) error {
+	input := XenvCreateFromTemplateInput{}
+	if err := readInput(apiContext, action, &input); err != nil {
+		return err
+	}
+	if input.TemplateID == "" {
+		return httperror.NewFieldAPIError(httperror.MissingRequired, "templateId", "")
+	}
+	if input.NamespaceID == "" {
+		return httperror.NewFieldAPIError(httperror.MissingRequired, "namespaceId", "")
+	}
+	if input.Name == "" {
+		return httperror.NewFieldAPIError(httperror.MissingRequired, "name", "")
+	}
+
+	template, err := h.templates.Template(input.TemplateID)
+	if err != nil {
+		if apiError, ok := err.(*httperror.APIError); ok && apiError.Code.Status == http.StatusNotFound {
+			return httperror.NewFieldAPIError(httperror.InvalidReference, "templateId", apiError.Message)
+		}
+		return err
+	}
+	key, err := generateSetupKey()
+	if err != nil {
+		return err
+	}
+
+	data, err := toXenvTemplateData(apiContext.Schema, input.Name, template)
+	if err != nil {
+		return err
+	}
+	delete(data, "id")
+	delete(data, "type")
+	delete(data, "description")
+	data["namespaceId"] = input.NamespaceID
+	data["key"] = key
+	if err := h.validator(apiContext, apiContext.Schema, data); err != nil {
+		return err
+	}
+
+	xenv, err := apiContext.Schema.Store.Create(apiContext, apiContext.Schema, data)
+	if err != nil {
+		return err
+	}
+	xenv["key"] = key
+	apiContext.WriteResponse(http.StatusCreated, xenv)
+	return nil
+}
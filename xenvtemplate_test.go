@@ -0,0 +1,72 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestXenvTemplateRegistry(t *testing.T) {
+	server, clientGetter := newFakeAPIServer(t, map[string]map[string]interface{}{
+		"/api/v1/namespaces/refunc/configmaps/xenv-templates": {
+			"apiVersion": "v1",
+			"kind":       "ConfigMap",
+			"metadata":   map[string]interface{}{"name": "xenv-templates", "namespace": "refunc"},
+			"data": map[string]interface{}{
+				"python3.7": "description: mirrored\ncontainer:\n  image: registry.example.com/python3.7\n",
+				"ruby2.5":   "description: Ruby 2.5 runtime\ncontainer:\n  image: registry.example.com/ruby2.5\n",
+				"broken":    "container: [1, 2",
+			},
+		},
+	})
+	defer server.Close()
+	configMaps := newCoreClient(clientGetter, "configmaps")
+
+	cases := []struct {
+		name      string
+		configMap string
+		images    map[string]string
+	}{
+		{
+			name: "built-in",
+			images: map[string]string{
+				"python3.7":  "refunc/lambda:python3.7",
+				"nodejs8.10": "refunc/lambda:nodejs8.10",
+				"go1.x":      "refunc/lambda:go1.x",
+			},
+		},
+		{
+			name:      "overridden by ConfigMap",
+			configMap: "refunc:xenv-templates",
+			images: map[string]string{
+				"python3.7":  "registry.example.com/python3.7",
+				"nodejs8.10": "refunc/lambda:nodejs8.10",
+				"go1.x":      "refunc/lambda:go1.x",
+				"ruby2.5":    "registry.example.com/ruby2.5",
+			},
+		},
+		{
+			name:      "ConfigMap not found",
+			configMap: "refunc:missing",
+			images: map[string]string{
+				"python3.7":  "refunc/lambda:python3.7",
+				"nodejs8.10": "refunc/lambda:nodejs8.10",
+				"go1.x":      "refunc/lambda:go1.x",
+			},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			templates, err := newXenvTemplateRegistry(configMaps, c.configMap).Templates()
+			if err != nil {
+				t.Fatal(err)
+			}
+			images := map[string]string{}
+			for name, template := range templates {
+				images[name] = template.Container.Image
+			}
+			if !reflect.DeepEqual(images, c.images) {
+				t.Errorf("images of templates = %v, want %v", images, c.images)
+			}
+		})
+	}
+}